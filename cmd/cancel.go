@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cheek "github.com/bart6114/cheek/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// cancelCmd represents the cancel command
+var cancelCmd = &cobra.Command{
+	Use:   "cancel {job_name} {run_id}",
+	Short: "Cancel a run of a job that is in progress",
+	Long: `Cancel a run of a job that is in progress
+
+The run is cancelled through the API of the cheek instance running the job,
+listening on the configured port of localhost. Use --url when cheek listens
+elsewhere or serves over https. When the API requires a token, pass one with
+the cancel scope with --token or CHEEK_TOKEN. Usage:
+'cheek cancel my_job 42'
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := cheek.NewConfig()
+		if err := viper.Unmarshal(&c); err != nil {
+			return err
+		}
+
+		if _, err := strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("run id should be a number: %w", err)
+		}
+
+		base := viper.GetString("url")
+		if base == "" {
+			base = fmt.Sprintf("http://localhost:%s", c.Port)
+		}
+		u := fmt.Sprintf("%s/api/jobs/%s/runs/%s/cancel", strings.TrimSuffix(base, "/"), url.PathEscape(args[0]), args[1])
+		req, err := http.NewRequest(http.MethodPost, u, nil)
+		if err != nil {
+			return err
+		}
+		if token := viper.GetString("token"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("cannot reach cheek: %w", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("not allowed to cancel runs (%s): %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+
+		var r cheek.Response
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("cannot cancel run %s of job %s: %s", args[1], args[0], r.Status)
+		}
+
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "cancelled run %s of job %s\n", args[1], args[0])
+		return nil
+	},
+}
+
+func init() {
+	cancelCmd.Flags().StringVar(&apiToken, "token", "", "api token to authenticate with, when the api requires one")
+	cancelCmd.Flags().StringVar(&apiURL, "url", "", "base url of the cheek api, defaults to http://localhost:{port}")
+	rootCmd.AddCommand(cancelCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelCmd(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") == "Bearer nope":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == "/api/jobs/bar/runs/42/cancel":
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"jobs":"bar","status":"ok","type":"cancel","run_id":42}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"jobs":"bar","status":"error: can't find run in progress to cancel","type":"cancel"}`))
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(bytes.Buffer)
+	rootCmd.SetOut(b)
+	defer rootCmd.SetOut(nil)
+	defer func(p string) { _ = rootCmd.PersistentFlags().Set("port", p) }(rootCmd.PersistentFlags().Lookup("port").Value.String())
+
+	rootCmd.SetArgs([]string{"cancel", "bar", "42", "--port", u.Port()})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "cancelled run 42 of job bar\n", b.String())
+
+	rootCmd.SetArgs([]string{"cancel", "bar", "43", "--port", u.Port()})
+	assert.ErrorContains(t, rootCmd.Execute(), "can't find run in progress")
+
+	rootCmd.SetArgs([]string{"cancel", "bar", "latest", "--port", u.Port()})
+	assert.Error(t, rootCmd.Execute())
+
+	rootCmd.SetArgs([]string{"cancel", "bar", "42", "--port", u.Port(), "--token", "nope"})
+	assert.ErrorContains(t, rootCmd.Execute(), "not allowed to cancel runs")
+	_ = cancelCmd.Flags().Set("token", "")
+
+	rootCmd.SetArgs([]string{"cancel", "bar", "42", "--url", srv.URL + "/"})
+	assert.NoError(t, rootCmd.Execute())
+	_ = cancelCmd.Flags().Set("url", "")
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	cheek "github.com/bart6114/cheek/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dryRun bool
+
+// dbCmd groups the commands that manage cheek's db
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the db used for logging",
+	Long:  "Manage the db used for logging",
+}
+
+// migrateCmd represents the db migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations to the db",
+	Long: `Apply pending schema migrations to the db
+
+Migrations are also applied automatically when cheek opens the db. Usage:
+'cheek db migrate --dry-run' to list the pending migrations without applying them
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := cheek.NewConfig()
+		if err := viper.Unmarshal(&c); err != nil {
+			return err
+		}
+
+		pending, err := cheek.MigrateDB(c.DBPath, dryRun)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if len(pending) == 0 {
+			_, _ = fmt.Fprintln(out, "db schema is up to date")
+			return nil
+		}
+
+		action := "applied"
+		if dryRun {
+			action = "pending"
+		}
+		for _, m := range pending {
+			_, _ = fmt.Fprintf(out, "%s: %d %s\n", action, m.Version, m.Description)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the pending migrations without applying them.")
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"bytes"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateCmd(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "cheek.sqlite3")
+	b := new(bytes.Buffer)
+	rootCmd.SetOut(b)
+	defer rootCmd.SetOut(nil)
+	defer func(p string) { _ = rootCmd.PersistentFlags().Set("dbpath", p) }(rootCmd.PersistentFlags().Lookup("dbpath").Value.String())
+
+	rootCmd.SetArgs([]string{"db", "migrate", "--dry-run", "--dbpath", dbPath})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Contains(t, b.String(), "pending: 1 create log table")
+
+	b.Reset()
+	rootCmd.SetArgs([]string{"db", "migrate", "--dry-run=false", "--dbpath", dbPath})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Contains(t, b.String(), "applied: 1 create log table")
+
+	b.Reset()
+	rootCmd.SetArgs([]string{"db", "migrate", "--dbpath", dbPath})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "db schema is up to date\n", b.String())
+}
@@ -14,6 +14,8 @@ var (
 	httpPort string
 	homeDir  string
 	dbPath   string
+	apiToken string
+	apiURL   string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -68,4 +70,12 @@ func initConfig() {
 	if err := viper.BindPFlag("dbpath", rootCmd.PersistentFlags().Lookup("dbpath")); err != nil {
 		fmt.Printf("error binding pflag %s", err)
 	}
+
+	if err := viper.BindPFlag("token", cancelCmd.Flags().Lookup("token")); err != nil {
+		fmt.Printf("error binding pflag %s", err)
+	}
+
+	if err := viper.BindPFlag("url", cancelCmd.Flags().Lookup("url")); err != nil {
+		fmt.Printf("error binding pflag %s", err)
+	}
 }
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	cheek "github.com/bart6114/cheek/pkg"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var tokenScopes []string
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token {name}",
+	Short: "Generate an API token",
+	Long: `Generate an API token
+
+Prints a new random token together with the entry to add to the api_tokens of
+your schedule, or to your api_tokens_file. Only the hash of the token ends up
+in the schedule, so keep the token itself somewhere safe. Usage:
+'cheek token ci --scopes read,trigger'
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t := cheek.APIToken{Name: args[0], Scopes: slices.Clone(tokenScopes)}
+		token, err := cheek.NewToken()
+		if err != nil {
+			return err
+		}
+		t.Hash = cheek.HashToken(token)
+
+		entry, err := yaml.Marshal([]cheek.APIToken{t})
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "token: %s\n\napi_tokens:\n%s", token, entry)
+		return nil
+	},
+}
+
+func init() {
+	tokenCmd.Flags().StringSliceVar(&tokenScopes, "scopes", []string{cheek.ScopeRead}, "scopes to grant the token, any of read,trigger,cancel,admin")
+	rootCmd.AddCommand(tokenCmd)
+}
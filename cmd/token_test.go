@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	cheek "github.com/bart6114/cheek/pkg"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTokenCmd(t *testing.T) {
+	b := new(bytes.Buffer)
+	rootCmd.SetOut(b)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"token", "ci", "--scopes", "read,trigger"})
+	assert.NoError(t, rootCmd.Execute())
+
+	var out struct {
+		Token     string           `yaml:"token"`
+		APITokens []cheek.APIToken `yaml:"api_tokens"`
+	}
+	assert.NoError(t, yaml.Unmarshal(b.Bytes(), &out))
+	assert.Len(t, out.Token, 64)
+	if assert.Len(t, out.APITokens, 1) {
+		assert.Equal(t, "ci", out.APITokens[0].Name)
+		assert.Equal(t, []string{"read", "trigger"}, out.APITokens[0].Scopes)
+		assert.Equal(t, cheek.HashToken(out.Token), out.APITokens[0].Hash)
+		assert.False(t, strings.Contains(out.APITokens[0].Hash, out.Token))
+	}
+}
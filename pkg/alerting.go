@@ -0,0 +1,150 @@
+package cheek
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Values of notify_on.
+const (
+	NotifyOnAlways = "always"
+	NotifyOnChange = "change"
+)
+
+var notifyOnModes = []string{NotifyOnAlways, NotifyOnChange}
+
+// countsAsOutcome reports whether a run with status tells something about the
+// health of a job, runs that were skipped, replaced or cancelled don't.
+func countsAsOutcome(status int) bool {
+	return status != StatusSkipped && status != StatusReplaced && status != StatusCancelled
+}
+
+func validateAlerting(notifyOn string, alertAfterFailures int, owner string) error {
+	if notifyOn != "" && !slices.Contains(notifyOnModes, notifyOn) {
+		return fmt.Errorf("notify_on '%s' of %s should be one of %s", notifyOn, owner, strings.Join(notifyOnModes, "|"))
+	}
+	if alertAfterFailures < 0 {
+		return fmt.Errorf("alert_after_failures of %s cannot be negative", owner)
+	}
+	return nil
+}
+
+// notifyOn returns the notify_on mode of the job, which defaults to that of
+// the schedule.
+func (j *JobSpec) notifyOn() string {
+	if j.NotifyOn != "" {
+		return j.NotifyOn
+	}
+	if s := j.globalSchedule; s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.NotifyOn != "" {
+			return s.NotifyOn
+		}
+	}
+	return NotifyOnAlways
+}
+
+// alertAfterFailures returns the number of consecutive failures after which
+// the job is considered failing, which defaults to that of the schedule.
+func (j *JobSpec) alertAfterFailures() int {
+	if j.AlertAfterFailures > 0 {
+		return j.AlertAfterFailures
+	}
+	if s := j.globalSchedule; s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.AlertAfterFailures > 0 {
+			return s.AlertAfterFailures
+		}
+	}
+	return 1
+}
+
+// limitsNotifications reports whether notifications of the job depend on its
+// health, rather than being sent for every run.
+func (j *JobSpec) limitsNotifications() bool {
+	return j.notifyOn() == NotifyOnChange || j.alertAfterFailures() > 1
+}
+
+// previousStatuses returns the statuses of at most n runs that finished
+// before jr, the most recent first. A run with retries counts once, with the
+// status of its last attempt, and runs that don't count as an outcome are
+// left out.
+func (j *JobSpec) previousStatuses(jr *JobRun, n int) ([]int, error) {
+	var statuses []int
+	if j.cfg.DB == nil {
+		// runs are kept in memory, jr included, the attempts of a run
+		// directly follow each other
+		runs := j.runs()
+		superseded := jr.RetryAttempt
+		for i := len(runs) - 1; i >= 0 && len(statuses) < n; i-- {
+			r := runs[i]
+			if r.TriggeredAt.Equal(jr.TriggeredAt) {
+				continue
+			}
+			if superseded > 0 {
+				superseded--
+				continue
+			}
+			superseded = r.RetryAttempt
+			if r.Status == nil || !countsAsOutcome(*r.Status) {
+				continue
+			}
+			statuses = append(statuses, *r.Status)
+		}
+		return statuses, nil
+	}
+
+	runId := jr.LogEntryId
+	if jr.ParentRunId != 0 {
+		runId = jr.ParentRunId
+	}
+	err := j.cfg.DB.Select(&statuses, `
+	SELECT status FROM log l
+	WHERE job = ? AND COALESCE(NULLIF(parent_run_id, 0), id) < ?
+		AND status IS NOT NULL AND status NOT IN (?, ?, ?)
+		AND NOT EXISTS (
+			SELECT 1 FROM log a
+			WHERE a.parent_run_id = COALESCE(NULLIF(l.parent_run_id, 0), l.id) AND a.attempt > l.attempt
+		)
+	ORDER BY id DESC LIMIT ?`,
+		j.Name, runId, StatusSkipped, StatusReplaced, StatusCancelled, n)
+	return statuses, err
+}
+
+// alertState decides, based on the runs before it, whether the notifiers of
+// the success or error events of jr are called, and whether jr recovers the
+// job from failing. A job is failing after alert_after_failures consecutive
+// failed runs.
+func (j *JobSpec) alertState(jr *JobRun) (notify, recovered bool) {
+	if !countsAsOutcome(*jr.Status) {
+		return true, false
+	}
+
+	n := j.alertAfterFailures()
+	prev, err := j.previousStatuses(jr, n)
+	if err != nil {
+		j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't load previous runs from db, notifying anyway.")
+		return true, false
+	}
+	failures := 0
+	for _, status := range prev {
+		if status == StatusOK {
+			break
+		}
+		failures++
+	}
+
+	if *jr.Status == StatusOK {
+		recovered = failures >= n
+		return j.notifyOn() == NotifyOnAlways || recovered, recovered
+	}
+
+	failures++ // this run
+	if j.notifyOn() == NotifyOnChange {
+		return failures == n, false
+	}
+	return failures >= n, false
+}
@@ -0,0 +1,200 @@
+package cheek
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertState(t *testing.T) {
+	const fail = 1
+	tests := []struct {
+		name               string
+		notifyOn           string
+		alertAfterFailures int
+		previous           []int // oldest first
+		status             int
+		wantNotify         bool
+		wantRecovered      bool
+	}{
+		{"first failure", "", 0, nil, fail, true, false},
+		{"first success", "", 0, nil, StatusOK, true, false},
+		{"recovery", "", 0, []int{fail}, StatusOK, true, true},
+		{"change, still ok", NotifyOnChange, 0, []int{StatusOK}, StatusOK, false, false},
+		{"change, first ok run", NotifyOnChange, 0, nil, StatusOK, false, false},
+		{"change, starts failing", NotifyOnChange, 0, []int{StatusOK}, fail, true, false},
+		{"change, still failing", NotifyOnChange, 0, []int{StatusOK, fail}, fail, false, false},
+		{"change, recovery", NotifyOnChange, 0, []int{fail, fail}, StatusOK, true, true},
+		{"change, timeout counts as failure", NotifyOnChange, 0, []int{fail}, StatusTimeout, false, false},
+		{"flapping, below threshold", "", 3, []int{StatusOK, fail}, fail, false, false},
+		{"flapping, at threshold", "", 3, []int{StatusOK, fail, fail}, fail, true, false},
+		{"flapping, beyond threshold", "", 3, []int{fail, fail, fail}, fail, true, false},
+		{"flapping, change beyond threshold", NotifyOnChange, 3, []int{fail, fail, fail}, fail, false, false},
+		{"flapping, no recovery below threshold", "", 3, []int{StatusOK, fail, fail}, StatusOK, true, false},
+		{"flapping, recovery", NotifyOnChange, 3, []int{fail, fail, fail, fail}, StatusOK, true, true},
+		{"skipped runs are ignored", NotifyOnChange, 0, []int{fail, StatusSkipped, StatusCancelled}, StatusOK, true, true},
+		{"skipped run", NotifyOnChange, 0, []int{StatusOK}, StatusSkipped, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSchedule(t, true, map[string]*JobSpec{
+				"job": {Command: []string{"true"}, NotifyOn: tt.notifyOn, AlertAfterFailures: tt.alertAfterFailures},
+			})
+			for _, status := range tt.previous {
+				_, err := s.cfg.DB.Exec("INSERT INTO log (job, triggered_at, triggered_by, status) VALUES ('job', ?, 'test', ?)", s.now(), status)
+				assert.NoError(t, err)
+			}
+			j := s.Jobs["job"]
+			jr := j.setup("test", nil)
+			jr.Status = &tt.status
+
+			notify, recovered := j.alertState(&jr)
+			assert.Equal(t, tt.wantNotify, notify, "notify")
+			assert.Equal(t, tt.wantRecovered, recovered, "recovered")
+		})
+	}
+}
+
+func TestAlertingDefaultsFromSchedule(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"default":  {Command: []string{"true"}},
+		"override": {Command: []string{"true"}, NotifyOn: NotifyOnAlways, AlertAfterFailures: 2},
+	})
+	assert.Equal(t, NotifyOnAlways, s.Jobs["default"].notifyOn())
+	assert.Equal(t, 1, s.Jobs["default"].alertAfterFailures())
+
+	s.NotifyOn = NotifyOnChange
+	s.AlertAfterFailures = 5
+	assert.Equal(t, NotifyOnChange, s.Jobs["default"].notifyOn())
+	assert.Equal(t, 5, s.Jobs["default"].alertAfterFailures())
+	assert.Equal(t, NotifyOnAlways, s.Jobs["override"].notifyOn())
+	assert.Equal(t, 2, s.Jobs["override"].alertAfterFailures())
+
+	assert.ErrorContains(t, validateAlerting("sometimes", 0, "the schedule"), "should be one of always|change")
+	assert.ErrorContains(t, validateAlerting("", -1, "the schedule"), "cannot be negative")
+}
+
+func TestAlertAfterFailuresWithRetries(t *testing.T) {
+	for name, withDB := range map[string]bool{"db": true, "in memory": false} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+			}))
+			defer testServer.Close()
+
+			s := newTestSchedule(t, withDB, map[string]*JobSpec{
+				"flaky": {
+					Command:            []string{"false"},
+					Retries:            2,
+					RetryDelay:         time.Millisecond,
+					AlertAfterFailures: 3,
+					OnError:            OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+				},
+			})
+			j := s.Jobs["flaky"]
+
+			// the attempts of a run count as a single failure
+			for i := 0; i < 2; i++ {
+				j.run(context.Background(), "test", nil)
+				s.deliveries.Wait()
+				mu.Lock()
+				assert.Zero(t, calls, "run %d", i+1)
+				mu.Unlock()
+			}
+
+			// a run with retries notifies once
+			j.run(context.Background(), "test", nil)
+			s.deliveries.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestNotifyOnChangeWithRetries(t *testing.T) {
+	for name, withDB := range map[string]bool{"db": true, "in memory": false} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+			}))
+			defer testServer.Close()
+
+			s := newTestSchedule(t, withDB, map[string]*JobSpec{
+				"flaky": {
+					Command:    []string{"false"},
+					Retries:    2,
+					RetryDelay: time.Millisecond,
+					NotifyOn:   NotifyOnChange,
+					OnError:    OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+				},
+			})
+			j := s.Jobs["flaky"]
+
+			// only the run that makes the job failing is alerted on, not
+			// each of its attempts or the runs after it
+			for i := 0; i < 2; i++ {
+				j.run(context.Background(), "test", nil)
+			}
+			s.deliveries.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestOnRecovery(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.URL.Path+" "+strings.TrimSpace(string(body)))
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"flaky": {
+			Command:            []string{"false"},
+			NotifyOn:           NotifyOnChange,
+			AlertAfterFailures: 2,
+			OnError:            OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/error", Template: "{{ .Status }}"}}},
+			OnSuccess:          OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/success", Template: "{{ .Status }}"}}},
+		},
+	})
+	s.OnRecovery = OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/recovery", Template: "{{ .StatusText }}"}}}
+	assert.NoError(t, s.ValidateNotifications())
+
+	j := s.Jobs["flaky"]
+	for i := 0; i < 3; i++ {
+		j.run(context.Background(), "test", nil)
+	}
+	j.Command = []string{"true"}
+	for i := 0; i < 2; i++ {
+		j.run(context.Background(), "test", nil)
+	}
+	s.deliveries.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// one alert once the job is failing, one success and one recovery once
+	// it works again
+	assert.ElementsMatch(t, []string{"/error 1", "/success 0", "/recovery ok"}, calls)
+}
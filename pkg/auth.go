@@ -0,0 +1,345 @@
+package cheek
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes an API token can be granted. The admin scope grants all others.
+const (
+	ScopeRead    = "read"
+	ScopeTrigger = "trigger"
+	ScopeCancel  = "cancel"
+	ScopeAdmin   = "admin"
+)
+
+var scopes = []string{ScopeRead, ScopeTrigger, ScopeCancel, ScopeAdmin}
+
+// tokenHashPrefix marks the hash function used for a token hash.
+const tokenHashPrefix = "sha256:"
+
+const (
+	// defaultSessionTTL is how long a login to the web UI lasts when no
+	// session_ttl is configured.
+	defaultSessionTTL = 12 * time.Hour
+	// sessionCookie is the name of the cookie holding the session id.
+	sessionCookie = "cheek_session"
+)
+
+// APIToken grants access to the API with the given scopes. Only the hash of
+// the token is kept in the schedule.
+type APIToken struct {
+	Name   string   `yaml:"name" json:"name"`
+	Hash   string   `yaml:"hash" json:"-"`
+	Scopes []string `yaml:"scopes" json:"scopes"`
+	hash   []byte
+}
+
+// NewToken generates a random API token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of a token as it should appear in the schedule.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func (t *APIToken) validate() error {
+	if t.Name == "" {
+		return errors.New("api tokens need a name")
+	}
+	hash, err := hex.DecodeString(strings.TrimPrefix(t.Hash, tokenHashPrefix))
+	if !strings.HasPrefix(t.Hash, tokenHashPrefix) || err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("hash of api token '%s' should be '%s' followed by a hex encoded SHA-256 hash", t.Name, tokenHashPrefix)
+	}
+	t.hash = hash
+	if len(t.Scopes) == 0 {
+		return fmt.Errorf("api token '%s' has no scopes", t.Name)
+	}
+	for _, scope := range t.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return fmt.Errorf("api token '%s' has unknown scope '%s', should be one of %s", t.Name, scope, strings.Join(scopes, "|"))
+		}
+	}
+	return nil
+}
+
+func (t *APIToken) hasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// ValidateAuth loads the tokens in api_tokens_file and validates all api
+// tokens of the schedule.
+func (s *Schedule) ValidateAuth() error {
+	tokens := slices.Clone(s.APITokens)
+	if s.APITokensFile != "" {
+		fn := s.APITokensFile
+		if !filepath.IsAbs(fn) && s.fn != "" {
+			fn = filepath.Join(filepath.Dir(s.fn), fn)
+		}
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("read api_tokens_file: %w", err)
+		}
+		var fileTokens []APIToken
+		if err := yaml.Unmarshal(b, &fileTokens); err != nil {
+			return fmt.Errorf("parse api_tokens_file: %w", err)
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+
+	names := make(map[string]bool, len(tokens))
+	for i := range tokens {
+		if err := tokens[i].validate(); err != nil {
+			return err
+		}
+		if names[tokens[i].Name] {
+			return fmt.Errorf("api token name '%s' is used more than once", tokens[i].Name)
+		}
+		names[tokens[i].Name] = true
+	}
+	if s.SessionTTL < 0 {
+		return errors.New("session_ttl cannot be negative")
+	}
+	s.tokens = tokens
+	return nil
+}
+
+func (s *Schedule) sessionTTL() time.Duration {
+	if s.SessionTTL > 0 {
+		return s.SessionTTL
+	}
+	return defaultSessionTTL
+}
+
+// authEnabled reports whether the API requires a token.
+func (s *Schedule) authEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.tokens) > 0
+}
+
+// authenticate looks up the api token matching token.
+func (s *Schedule) authenticate(token string) (APIToken, bool) {
+	sum := sha256.Sum256([]byte(token))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.hash) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// tokenByName looks up a token of a logged in session, so sessions end when
+// their token is removed from the schedule.
+func (s *Schedule) tokenByName(name string) (APIToken, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// principal returns the api token a request was made with, either as a
+// bearer token or through the session of a login.
+func (s *Schedule) principal(r *http.Request) (APIToken, bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return APIToken{}, false
+		}
+		return s.authenticate(token)
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if name, ok := s.sessions.get(c.Value, time.Now()); ok {
+			return s.tokenByName(name)
+		}
+	}
+	return APIToken{}, false
+}
+
+// sessionRegistry keeps track of the logins to the web UI, keyed by session
+// id. Sessions don't survive a restart.
+type sessionRegistry struct {
+	mutex    sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	token   string
+	expires time.Time
+}
+
+func (r *sessionRegistry) add(token string, expires time.Time) (string, error) {
+	id, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]session)
+	}
+	// drop expired sessions while at it
+	for k, v := range r.sessions {
+		if v.expires.Before(time.Now()) {
+			delete(r.sessions, k)
+		}
+	}
+	r.sessions[id] = session{token: token, expires: expires}
+	return id, nil
+}
+
+func (r *sessionRegistry) get(id string, now time.Time) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sess, ok := r.sessions[id]
+	if !ok || sess.expires.Before(now) {
+		return "", false
+	}
+	return sess.token, true
+}
+
+func (r *sessionRegistry) remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, id)
+}
+
+// authorize requires requests to be made with a token that has the given
+// scope, when the schedule has api tokens.
+func authorize(s *Schedule, scope string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !s.authEnabled() {
+			h(w, r, ps)
+			return
+		}
+		t, ok := s.principal(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cheek"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !t.hasScope(scope) {
+			http.Error(w, fmt.Sprintf("token '%s' lacks the %s scope", t.Name, scope), http.StatusForbidden)
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// authorizePage is authorize for the pages of the web UI, visitors that are
+// not logged in are sent to the login page.
+func authorizePage(s *Schedule, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if s.authEnabled() {
+			if _, ok := s.principal(r); !ok {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+		}
+		authorize(s, ScopeRead, h)(w, r, ps)
+	}
+}
+
+// safeRedirect only allows redirects to paths on this server after a login.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func getLoginPage() httprouter.Handle {
+	tmpl, err := template.ParseFS(fsys(), "templates/login.html")
+	if err != nil {
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		data := struct {
+			Error bool
+			Next  string
+		}{
+			Error: r.URL.Query().Has("error"),
+			Next:  safeRedirect(r.URL.Query().Get("next")),
+		}
+		if err := tmpl.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func postLogin(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		next := safeRedirect(r.PostFormValue("next"))
+		t, ok := s.authenticate(r.PostFormValue("token"))
+		if !ok {
+			s.log.Warn().Str("remote_addr", r.RemoteAddr).Msg("Failed login to the web UI")
+			http.Redirect(w, r, "/login?error&next="+url.QueryEscape(next), http.StatusSeeOther)
+			return
+		}
+
+		ttl := s.sessionTTL()
+		id, err := s.sessions.add(t.Name, time.Now().Add(ttl))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		s.log.Info().Str("token", t.Name).Msg("Logged in to the web UI")
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+func postLogout(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			s.sessions.remove(c.Value)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
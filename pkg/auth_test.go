@@ -0,0 +1,189 @@
+package cheek
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthSchedule(t *testing.T, tokens ...APIToken) *Schedule {
+	t.Helper()
+	s := &Schedule{
+		Jobs: map[string]*JobSpec{
+			"bertha": {Command: []string{"true"}},
+		},
+		APITokens: tokens,
+		log:       zerolog.Nop(),
+		cfg:       NewConfig(),
+	}
+	if err := s.initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func doRequest(router http.Handler, method, target, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthDisabled(t *testing.T) {
+	router := setupRouter(newAuthSchedule(t))
+
+	assert.Equal(t, http.StatusOK, doRequest(router, "GET", "/api/jobs", "").Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, "GET", "/", "").Code)
+}
+
+func TestAuthScopes(t *testing.T) {
+	s := newAuthSchedule(t,
+		APIToken{Name: "reader", Hash: HashToken("r"), Scopes: []string{ScopeRead}},
+		APIToken{Name: "admin", Hash: HashToken("a"), Scopes: []string{ScopeAdmin}},
+	)
+	router := setupRouter(s)
+
+	tests := []struct {
+		method, target, token string
+		wantCode              int
+	}{
+		{"GET", "/healthz/", "", http.StatusOK},
+		{"GET", "/api/jobs", "", http.StatusUnauthorized},
+		{"GET", "/api/jobs", "wrong", http.StatusUnauthorized},
+		{"GET", "/api/jobs", "r", http.StatusOK},
+		{"GET", "/metrics", "r", http.StatusOK},
+		{"POST", "/api/jobs/bertha/trigger", "r", http.StatusForbidden},
+		{"POST", "/api/jobs/bertha/runs/1/cancel", "r", http.StatusForbidden},
+		{"POST", "/api/jobs/bertha/runs/1/cancel", "a", http.StatusNotFound},
+		{"GET", "/api/jobs", "a", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := doRequest(router, tt.method, tt.target, tt.token)
+		assert.Equal(t, tt.wantCode, rr.Code, "%s %s with token %q", tt.method, tt.target, tt.token)
+	}
+
+	rr := doRequest(router, "GET", "/api/jobs", "")
+	assert.Equal(t, `Bearer realm="cheek"`, rr.Header().Get("WWW-Authenticate"))
+}
+
+func TestLogin(t *testing.T) {
+	s := newAuthSchedule(t, APIToken{Name: "reader", Hash: HashToken("r"), Scopes: []string{ScopeRead}})
+	router := setupRouter(s)
+
+	// pages redirect to the login page
+	rr := doRequest(router, "GET", "/jobs/bertha/latest", "")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login?next=%2Fjobs%2Fbertha%2Flatest", rr.Header().Get("Location"))
+
+	rr = doRequest(router, "GET", "/login?next=%2Fjobs%2Fbertha%2Flatest", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="/jobs/bertha/latest"`)
+
+	login := func(token, next string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "next": {next}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = login("wrong", "/jobs/bertha/latest")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login?error&next=%2Fjobs%2Fbertha%2Flatest", rr.Header().Get("Location"))
+	assert.Empty(t, rr.Result().Cookies())
+
+	rr = login("r", "//evil.example.com")
+	assert.Equal(t, "/", rr.Header().Get("Location"), "should not redirect off site")
+
+	rr = login("r", "/jobs/bertha/latest")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/jobs/bertha/latest", rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	// the session grants the scopes of the token
+	assert.Equal(t, http.StatusOK, doRequest(router, "GET", "/jobs/bertha/latest", "", cookies[0]).Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, "GET", "/api/jobs", "", cookies[0]).Code)
+	assert.Equal(t, http.StatusForbidden, doRequest(router, "POST", "/api/jobs/bertha/trigger", "", cookies[0]).Code)
+	assert.Contains(t, doRequest(router, "GET", "/", "", cookies[0]).Body.String(), `action="/logout"`)
+
+	rr = doRequest(router, "POST", "/logout", "", cookies[0])
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(router, "GET", "/api/jobs", "", cookies[0]).Code)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	var r sessionRegistry
+	id, err := r.add("reader", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	name, ok := r.get(id, time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, "reader", name)
+
+	_, ok = r.get(id, time.Now().Add(2*time.Minute))
+	assert.False(t, ok)
+	_, ok = r.get("unknown", time.Now())
+	assert.False(t, ok)
+}
+
+func TestValidateAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  []APIToken
+		wantErr string
+	}{
+		{"no name", []APIToken{{Hash: HashToken("a"), Scopes: []string{ScopeRead}}}, "need a name"},
+		{"plain token", []APIToken{{Name: "a", Hash: "a", Scopes: []string{ScopeRead}}}, "SHA-256 hash"},
+		{"short hash", []APIToken{{Name: "a", Hash: "sha256:abcd", Scopes: []string{ScopeRead}}}, "SHA-256 hash"},
+		{"no scopes", []APIToken{{Name: "a", Hash: HashToken("a")}}, "has no scopes"},
+		{"unknown scope", []APIToken{{Name: "a", Hash: HashToken("a"), Scopes: []string{"write"}}}, "unknown scope 'write'"},
+		{"duplicate", []APIToken{
+			{Name: "a", Hash: HashToken("a"), Scopes: []string{ScopeRead}},
+			{Name: "a", Hash: HashToken("b"), Scopes: []string{ScopeRead}},
+		}, "used more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Schedule{APITokens: tt.tokens}
+			assert.ErrorContains(t, s.ValidateAuth(), tt.wantErr)
+		})
+	}
+}
+
+func TestAPITokensFile(t *testing.T) {
+	dir := t.TempDir()
+	tokens := "- name: ci\n  hash: " + HashToken("c") + "\n  scopes: [trigger]\n"
+	assert.NoError(t, os.WriteFile(path.Join(dir, "tokens.yaml"), []byte(tokens), 0o600))
+
+	s := &Schedule{APITokensFile: "tokens.yaml", fn: path.Join(dir, "schedule.yaml")}
+	assert.NoError(t, s.ValidateAuth())
+
+	tok, ok := s.authenticate("c")
+	assert.True(t, ok)
+	assert.Equal(t, "ci", tok.Name)
+	assert.True(t, tok.hasScope(ScopeTrigger))
+	assert.False(t, tok.hasScope(ScopeRead))
+
+	s = &Schedule{APITokensFile: "missing.yaml", fn: path.Join(dir, "schedule.yaml")}
+	assert.ErrorContains(t, s.ValidateAuth(), "read api_tokens_file")
+}
@@ -0,0 +1,78 @@
+package cheek
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errRunCancelled is the cancellation cause of a run that was stopped on
+// request of a user.
+var errRunCancelled = errors.New("cancelled by a user")
+
+// runRegistry keeps track of the runs in flight, keyed by run id, so they can
+// be cancelled.
+type runRegistry struct {
+	mutex sync.Mutex
+	runs  map[int]activeRun
+}
+
+type activeRun struct {
+	job    string
+	cancel context.CancelCauseFunc
+}
+
+func (r *runRegistry) add(id int, job string, cancel context.CancelCauseFunc) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.runs == nil {
+		r.runs = make(map[int]activeRun)
+	}
+	r.runs[id] = activeRun{job: job, cancel: cancel}
+}
+
+func (r *runRegistry) remove(id int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.runs, id)
+}
+
+// cancel stops the run of job with the given id, it returns false when no
+// such run is in flight.
+func (r *runRegistry) cancel(job string, id int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	run, ok := r.runs[id]
+	if !ok || run.job != job {
+		return false
+	}
+	run.cancel(errRunCancelled)
+	return true
+}
+
+// trackRun registers the run with the schedule so it can be cancelled. It
+// returns the context to execute the run with and a func to call when done.
+func (j *JobSpec) trackRun(ctx context.Context, jr *JobRun) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := j.globalSchedule
+	if s == nil || jr.LogEntryId == 0 {
+		return ctx, func() { cancel(nil) }
+	}
+
+	s.active.add(jr.LogEntryId, j.Name, cancel)
+	return ctx, func() {
+		s.active.remove(jr.LogEntryId)
+		cancel(nil)
+	}
+}
+
+// CancelRun cancels the run with the given id, or the run it is an attempt
+// of. It returns false when the run is not in flight.
+func (s *Schedule) CancelRun(j *JobSpec, id int) bool {
+	if s.cfg.DB != nil {
+		if jr, err := j.loadLogFromDb(id); err == nil && jr.ParentRunId != 0 {
+			id = jr.ParentRunId
+		}
+	}
+	return s.active.cancel(j.Name, id)
+}
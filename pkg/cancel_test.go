@@ -0,0 +1,123 @@
+package cheek
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestRun sets up a run and executes it in the background.
+func startTestRun(j *JobSpec) (JobRun, <-chan JobRun) {
+	jr := j.setup("test", nil)
+	done := make(chan JobRun, 1)
+	go func() {
+		done <- j.execRun(context.Background(), jr, "test")
+	}()
+	return jr, done
+}
+
+func waitForRun(t *testing.T, done <-chan JobRun) JobRun {
+	t.Helper()
+	select {
+	case jr := <-done:
+		return jr
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not finish")
+		return JobRun{}
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"long": {
+			Command:         []string{"sleep", "10"},
+			Retries:         3,
+			RetryDelay:      10 * time.Millisecond,
+			KillGracePeriod: 100 * time.Millisecond,
+			OnCancel:        OnEvent{TriggerJob: []string{"notify"}},
+			OnError:         OnEvent{TriggerJob: []string{"on_error"}},
+		},
+		"notify":   {Command: []string{"true"}},
+		"on_error": {Command: []string{"true"}},
+	})
+	j := s.Jobs["long"]
+
+	jr, done := startTestRun(j)
+	waitForRunning(t, j, 1)
+
+	assert.False(t, s.CancelRun(s.Jobs["notify"], jr.LogEntryId), "run belongs to another job")
+	assert.False(t, s.CancelRun(j, jr.LogEntryId+100))
+	assert.True(t, s.CancelRun(j, jr.LogEntryId))
+
+	jr = waitForRun(t, done)
+	assert.Equal(t, StatusCancelled, *jr.Status)
+	assert.Contains(t, jr.Log, "Job killed on request")
+	assert.Equal(t, 0, jr.RetryAttempt, "cancelled runs should not be retried")
+	assert.False(t, s.CancelRun(j, jr.LogEntryId), "run is no longer in flight")
+
+	var triggered []string
+	assert.NoError(t, s.cfg.DB.Select(&triggered, "SELECT job FROM log WHERE job != 'long' AND job != ?", jobNameCoreProcess))
+	assert.Equal(t, []string{"notify"}, triggered)
+}
+
+func TestCancelQueuedRun(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"long": {
+			Command:         []string{"sleep", "10"},
+			OverlapPolicy:   OverlapQueue,
+			KillGracePeriod: 100 * time.Millisecond,
+		},
+	})
+	j := s.Jobs["long"]
+
+	first, firstDone := startTestRun(j)
+	waitForRunning(t, j, 1)
+	queued, queuedDone := startTestRun(j)
+	assert.Eventually(t, func() bool {
+		_, queued := j.overlapState()
+		return queued == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.True(t, s.CancelRun(j, queued.LogEntryId))
+	queued = waitForRun(t, queuedDone)
+	assert.Equal(t, StatusCancelled, *queued.Status)
+	assert.Equal(t, "Job cancelled on request", queued.Log)
+
+	running, _ := j.overlapState()
+	assert.Equal(t, 1, running, "the first run should not be affected")
+
+	assert.True(t, s.CancelRun(j, first.LogEntryId))
+	first = waitForRun(t, firstDone)
+	assert.Equal(t, StatusCancelled, *first.Status)
+}
+
+func TestCancelEndpoint(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"long": {Command: []string{"sleep", "10"}, KillGracePeriod: 100 * time.Millisecond},
+	})
+	handler := setupRouter(s)
+
+	jr, done := startTestRun(s.Jobs["long"])
+	waitForRunning(t, s.Jobs["long"], 1)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/api/jobs/long/runs/abc/cancel", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", fmt.Sprintf("/api/jobs/long/runs/%d/cancel", jr.LogEntryId), nil))
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.Contains(t, resp.Body.String(), "\"type\":\"cancel\"")
+
+	jr = waitForRun(t, done)
+	assert.Equal(t, StatusCancelled, *jr.Status)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", fmt.Sprintf("/api/jobs/long/runs/%d/cancel", jr.LogEntryId), nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
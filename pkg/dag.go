@@ -0,0 +1,227 @@
+package cheek
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Conditions under which a finished upstream run counts towards depends_on.
+const (
+	DependsOnSuccess   = "success"
+	DependsOnCompleted = "completed"
+)
+
+// defaultDependsOnWindow is the time all dependencies of a job get to finish,
+// counted from the first one, when no depends_on_window is configured.
+const defaultDependsOnWindow = 24 * time.Hour
+
+// dependencyCycle collects the upstream runs of a job with depends_on until
+// all of its dependencies are satisfied.
+type dependencyCycle struct {
+	mutex   sync.Mutex
+	started time.Time
+	runs    map[string]*JobRun
+}
+
+// RunLineageEdge links a run to one of the runs that caused it.
+type RunLineageEdge struct {
+	RunId       int `json:"run_id" db:"run_id"`
+	ParentRunId int `json:"parent_run_id" db:"parent_run_id"`
+}
+
+// RunLineage holds all runs of a graph instance and how they are linked.
+type RunLineage struct {
+	Runs  []JobRun         `json:"runs"`
+	Edges []RunLineageEdge `json:"edges"`
+}
+
+func (j *JobSpec) ValidateDependsOn() error {
+	switch j.DependsOnCondition {
+	case "", DependsOnSuccess, DependsOnCompleted:
+	default:
+		return fmt.Errorf("depends_on_condition '%s' for job '%s' not valid, should be one of %s|%s", j.DependsOnCondition, j.Name, DependsOnSuccess, DependsOnCompleted)
+	}
+	if j.DependsOnWindow < 0 {
+		return fmt.Errorf("depends_on_window for job '%s' cannot be negative", j.Name)
+	}
+	if slices.Contains(j.DependsOn, j.Name) {
+		return fmt.Errorf("job '%s' cannot depend on itself", j.Name)
+	}
+	return nil
+}
+
+func (j *JobSpec) dependsOnWindow() time.Duration {
+	if j.DependsOnWindow > 0 {
+		return j.DependsOnWindow
+	}
+	return defaultDependsOnWindow
+}
+
+// recordDependencyRun adds a finished upstream run to the current cycle. When
+// this satisfies all dependencies the collected runs are returned and a new
+// cycle is started.
+func (j *JobSpec) recordDependencyRun(jr *JobRun) ([]*JobRun, bool) {
+	c := &j.deps
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := j.now()
+	if c.runs != nil && now.Sub(c.started) > j.dependsOnWindow() {
+		j.log.Info().Str("job", j.Name).Msgf("Dependencies not satisfied within %v, discarding collected runs", j.dependsOnWindow())
+		c.runs = nil
+	}
+
+	if j.DependsOnCondition != DependsOnCompleted && (jr.Status == nil || *jr.Status != StatusOK) {
+		return nil, false
+	}
+
+	if c.runs == nil {
+		c.runs = make(map[string]*JobRun, len(j.DependsOn))
+		c.started = now
+	}
+	c.runs[jr.Name] = jr
+
+	runs := make([]*JobRun, 0, len(j.DependsOn))
+	for _, d := range j.DependsOn {
+		r, ok := c.runs[d]
+		if !ok {
+			return nil, false
+		}
+		runs = append(runs, r)
+	}
+
+	c.runs = nil
+	return runs, true
+}
+
+// notifyDependents passes a finished run to the jobs that depend on it and
+// triggers the ones whose dependencies are now all satisfied.
+func (j *JobSpec) notifyDependents(jr *JobRun) {
+	if j.globalSchedule == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range j.globalSchedule.jobs() {
+		if !slices.Contains(d.DependsOn, j.Name) {
+			continue
+		}
+
+		runs, ok := d.recordDependencyRun(jr)
+		if !ok {
+			continue
+		}
+
+		j.log.Debug().Str("job", d.Name).Str("on_event", "depends_on").Msg("dependencies satisfied")
+		wg.Add(1)
+		go func(d *JobSpec, runs []*JobRun) {
+			defer wg.Done()
+			// Use background context for triggered jobs (they should complete independently)
+			d.run(context.Background(), fmt.Sprintf("depends_on[%s]", strings.Join(d.DependsOn, ",")), nil, runs...)
+		}(d, runs)
+	}
+
+	wg.Wait()
+}
+
+// validateDependencies checks that depends_on references exist and that
+// they don't form a cycle.
+func (s *Schedule) validateDependencies() error {
+	names := make([]string, 0, len(s.Jobs))
+	for k, v := range s.Jobs {
+		names = append(names, k)
+		for _, d := range v.DependsOn {
+			if _, ok := s.Jobs[d]; !ok {
+				return fmt.Errorf("cannot find spec of job '%s' that is referenced in depends_on of job '%s'", d, k)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(names))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := slices.Index(path, name)
+			return fmt.Errorf("depends_on cycle detected: %s", strings.Join(append(path[start:], name), " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, d := range s.Jobs[name].DependsOn {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logLineage persists the links between a run and the runs that caused it.
+func (jr *JobRun) logLineage() {
+	if jr.jobRef.cfg.DB == nil || jr.LogEntryId == 0 {
+		return
+	}
+
+	parents := jr.DependencyRuns
+	if jr.TriggeredByJobRun != nil {
+		parents = append([]*JobRun{jr.TriggeredByJobRun}, parents...)
+	}
+
+	for _, p := range parents {
+		if p.LogEntryId == 0 {
+			continue
+		}
+		_, err := jr.jobRef.cfg.DB.Exec("INSERT INTO run_lineage (run_id, parent_run_id) VALUES (?, ?) ON CONFLICT DO NOTHING", jr.LogEntryId, p.LogEntryId)
+		if err != nil {
+			jr.jobRef.log.Warn().Str("job", jr.Name).Err(err).Msg("Couldn't save run lineage to db.")
+		}
+	}
+}
+
+// loadRunLineage loads the graph instance a run is part of: every run that
+// can be reached from it by following lineage links in either direction.
+func loadRunLineage(db *sqlx.DB, id int) (RunLineage, error) {
+	const graph = `
+		WITH RECURSIVE graph(id) AS (
+			SELECT ?
+			UNION
+			SELECT CASE WHEN l.run_id = g.id THEN l.parent_run_id ELSE l.run_id END
+			FROM run_lineage l JOIN graph g ON l.run_id = g.id OR l.parent_run_id = g.id
+		)`
+
+	rl := RunLineage{Runs: []JobRun{}, Edges: []RunLineageEdge{}}
+	if err := db.Select(&rl.Runs, graph+"SELECT id, job, triggered_at, triggered_by, duration, status FROM log WHERE id IN (SELECT id FROM graph) ORDER BY triggered_at", id); err != nil {
+		return rl, err
+	}
+	if err := db.Select(&rl.Edges, graph+"SELECT run_id, parent_run_id FROM run_lineage WHERE run_id IN (SELECT id FROM graph)", id); err != nil {
+		return rl, err
+	}
+	return rl, nil
+}
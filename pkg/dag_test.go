@@ -0,0 +1,121 @@
+package cheek
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependsOnValidation(t *testing.T) {
+	s := &Schedule{
+		Jobs: map[string]*JobSpec{
+			"a": {Command: []string{"true"}, DependsOn: []string{"c"}},
+			"b": {Command: []string{"true"}, DependsOn: []string{"a"}},
+			"c": {Command: []string{"true"}, DependsOn: []string{"b"}},
+		},
+		cfg: NewConfig(),
+	}
+	err := s.initialize()
+	assert.EqualError(t, err, "depends_on cycle detected: a -> c -> b -> a")
+
+	s.Jobs["a"].DependsOn = []string{"does_not_exist"}
+	assert.Error(t, s.initialize())
+
+	s.Jobs["a"].DependsOn = []string{"a"}
+	assert.Error(t, s.initialize())
+
+	s.Jobs["a"].DependsOn = nil
+	s.Jobs["b"].DependsOnCondition = "whenever"
+	assert.Error(t, s.initialize())
+
+	s.Jobs["b"].DependsOnCondition = DependsOnCompleted
+	assert.NoError(t, s.initialize())
+}
+
+func TestDependsOnFanIn(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"a": {Command: []string{"true"}},
+		"b": {Command: []string{"true"}},
+		"c": {Command: []string{"echo", "c"}, DependsOn: []string{"a", "b"}},
+	})
+
+	s.Jobs["a"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Empty(t, s.Jobs["c"].Runs, "c should wait for b")
+
+	s.Jobs["b"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Len(t, s.Jobs["c"].Runs, 1)
+
+	jr := s.Jobs["c"].Runs[0]
+	assert.Equal(t, "depends_on[a,b]", jr.TriggeredBy)
+	assert.Len(t, jr.DependencyRuns, 2)
+	assert.Equal(t, "a", jr.DependencyRuns[0].Name)
+	assert.Equal(t, "b", jr.DependencyRuns[1].Name)
+
+	// a new cycle starts after c was triggered
+	s.Jobs["b"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Len(t, s.Jobs["c"].Runs, 1)
+}
+
+func TestDependsOnCondition(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"failing": {Command: []string{"false"}},
+		"on_success": {
+			Command:   []string{"true"},
+			DependsOn: []string{"failing"},
+		},
+		"on_completion": {
+			Command:            []string{"true"},
+			DependsOn:          []string{"failing"},
+			DependsOnCondition: DependsOnCompleted,
+		},
+	})
+
+	s.Jobs["failing"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Empty(t, s.Jobs["on_success"].Runs)
+	assert.Len(t, s.Jobs["on_completion"].Runs, 1)
+}
+
+func TestDependsOnWindow(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"a": {Command: []string{"true"}},
+		"b": {Command: []string{"true"}},
+		"c": {
+			Command:         []string{"true"},
+			DependsOn:       []string{"a", "b"},
+			DependsOnWindow: 50 * time.Millisecond,
+		},
+	})
+
+	s.Jobs["a"].execCommandWithRetry(context.Background(), "test", nil)
+	time.Sleep(100 * time.Millisecond)
+	s.Jobs["b"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Empty(t, s.Jobs["c"].Runs, "run of a fell outside of the window")
+
+	s.Jobs["a"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Len(t, s.Jobs["c"].Runs, 1)
+}
+
+func TestRunLineage(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"a": {Command: []string{"true"}, OnSuccess: OnEvent{TriggerJob: []string{"b"}}},
+		"b": {Command: []string{"true"}},
+		"c": {Command: []string{"true"}},
+		"d": {Command: []string{"true"}, DependsOn: []string{"b", "c"}},
+	})
+
+	jr := s.Jobs["a"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.NotZero(t, jr.LogEntryId)
+	s.Jobs["c"].execCommandWithRetry(context.Background(), "test", nil)
+
+	rl, err := loadRunLineage(s.cfg.DB, jr.LogEntryId)
+	assert.NoError(t, err)
+
+	var jobs []string
+	for _, r := range rl.Runs {
+		jobs = append(jobs, r.Name)
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, jobs)
+	assert.Len(t, rl.Edges, 3)
+}
@@ -21,33 +21,27 @@ func OpenDB(dbPath string) (*sqlx.DB, error) {
 	return db, nil
 }
 
+// InitDB brings the schema of the db up to date by running all pending
+// migrations.
 func InitDB(db *sqlx.DB) error {
-	// Create the log table if it doesn't exist
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS log (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        job TEXT,
-        triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		triggered_by TEXT,
-        duration INTEGER,
-        status INTEGER,
-        message TEXT,
-		UNIQUE(job, triggered_at, triggered_by)
-    )`)
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
 	if err != nil {
-		return fmt.Errorf("create log table: %w", err)
+		return fmt.Errorf("create schema_version table: %w", err)
 	}
 
-	// Perform cleanup to remove old, non-conforming records
-	_, err = db.Exec(`
-		DELETE FROM log
-		WHERE id NOT IN (
-			SELECT MIN(id)
-			FROM log
-			GROUP BY job, triggered_at, triggered_by
-		);
-	`)
+	pending, err := PendingMigrations(db)
 	if err != nil {
-		return fmt.Errorf("cleanup old log records: %w", err)
+		return err
+	}
+
+	for _, m := range pending {
+		if err := m.apply(db); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
 	}
 
 	return nil
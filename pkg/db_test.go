@@ -86,4 +86,10 @@ func TestInitDB(t *testing.T) {
 	assert.NoError(t, err, "Querying the log table should not return an error")
 	assert.Equal(t, 2, cleanedCount, "There should be 2 unique records in the log table after cleanup")
 
+	// Check that the columns of retry attempts were added to the existing table
+	var attempts int
+	err = db.Get(&attempts, "SELECT COUNT(*) FROM log WHERE attempt = 0 AND parent_run_id = 0")
+	assert.NoError(t, err, "The attempt columns should exist after InitDB")
+	assert.Equal(t, 2, attempts)
+
 }
@@ -0,0 +1,230 @@
+package cheek
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defaults for the delivery of webhook notifications.
+const (
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookRetries    = 2
+	defaultWebhookRetryDelay = time.Second
+)
+
+// signatureHeader holds the HMAC-SHA256 of the body of a webhook call, when
+// the webhook has a secret.
+const signatureHeader = "X-Cheek-Signature-256"
+
+// WebhookDelivery records an attempt to deliver a notification for a run.
+type WebhookDelivery struct {
+	Id         int           `json:"id" db:"id"`
+	RunId      int           `json:"run_id" db:"run_id"`
+	Job        string        `json:"job" db:"job"`
+	Webhook    string        `json:"webhook" db:"webhook"`
+	Host       string        `json:"host" db:"host"`
+	Attempt    int           `json:"attempt" db:"attempt"`
+	StatusCode int           `json:"status_code,omitempty" db:"status_code"`
+	Error      string        `json:"error,omitempty" db:"error"`
+	Duration   time.Duration `json:"duration" db:"duration"`
+	SentAt     time.Time     `json:"sent_at" db:"sent_at"`
+}
+
+// DeliveryOptions control how a notification is delivered, they are shared
+// by all notifiers.
+type DeliveryOptions struct {
+	// Timeout limits each attempt, Retries and RetryDelay control how failed
+	// attempts are retried.
+	Timeout    time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Retries    *int          `yaml:"retries,omitempty" json:"retries,omitempty"`
+	RetryDelay time.Duration `yaml:"retry_delay,omitempty" json:"retry_delay,omitempty"`
+}
+
+func (o DeliveryOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return defaultWebhookTimeout
+}
+
+func (o DeliveryOptions) retries() int {
+	if o.Retries != nil {
+		return *o.Retries
+	}
+	return defaultWebhookRetries
+}
+
+// retryDelay doubles the delay with each retry.
+func (o DeliveryOptions) retryDelay(retry int) time.Duration {
+	delay := defaultWebhookRetryDelay
+	if o.RetryDelay > 0 {
+		delay = o.RetryDelay
+	}
+	return delay << (retry - 1)
+}
+
+func (o DeliveryOptions) validate() error {
+	if o.Timeout < 0 || o.RetryDelay < 0 {
+		return errors.New("timeout and retry_delay cannot be negative")
+	}
+	if o.Retries != nil && *o.Retries < 0 {
+		return errors.New("retries cannot be negative")
+	}
+	return nil
+}
+
+// deliver makes attempts with call until one succeeds, retryable tells it
+// not to try again or the retries run out. Every attempt is recorded for the
+// run as d.
+func (o DeliveryOptions) deliver(jr *JobRun, d WebhookDelivery, retryable func(code int, err error) bool, call func() (int, []byte, error)) ([]byte, error) {
+	var (
+		resp []byte
+		err  error
+	)
+	for attempt := 0; attempt <= o.retries(); attempt++ {
+		if attempt > 0 {
+			time.Sleep(o.retryDelay(attempt))
+		}
+
+		var code int
+		start := time.Now()
+		code, resp, err = call()
+		d.Attempt = attempt
+		d.StatusCode = code
+		d.Error = errorString(err)
+		d.Duration = time.Since(start)
+		d.SentAt = start
+		jr.logDelivery(d)
+
+		if !retryable(code, err) {
+			break
+		}
+	}
+	return resp, err
+}
+
+// sign returns the signature of body with the secret of the target.
+func (t WebhookTarget) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(t.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableHTTP reports whether a failed call is worth another try: when no
+// response was received, or the server is failing or rate limiting.
+func retryableHTTP(statusCode int, err error) bool {
+	if statusCode == 0 {
+		return err != nil
+	}
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// send calls the target with the given JSON body, retrying on network errors
+// and server errors.
+func (t WebhookTarget) send(jr *JobRun, name string, body []byte) ([]byte, error) {
+	return t.sendWithHeader(jr, name, http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// sendWithHeader is send with the default headers of the request, the
+// headers of the target take precedence.
+func (t WebhookTarget) sendWithHeader(jr *JobRun, name string, header http.Header, body []byte) ([]byte, error) {
+	client := http.Client{Timeout: t.timeout()}
+	d := WebhookDelivery{Webhook: name, Host: webhookHost(t.URL)}
+	return t.deliver(jr, d, retryableHTTP, func() (int, []byte, error) {
+		return t.do(&client, header, body)
+	})
+}
+
+// do makes a single call to the target.
+func (t WebhookTarget) do(client *http.Client, header http.Header, body []byte) (int, []byte, error) {
+	method := t.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, []byte{}, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for k, v := range t.Headers {
+		req.Header.Set(k, string(v))
+	}
+	if t.Secret != "" {
+		req.Header.Set(signatureHeader, t.sign(body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, []byte{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	resp_body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, []byte{}, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, resp_body, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, resp_body, nil
+}
+
+// webhookHost is the part of a webhook URL that is safe to store, as the
+// path often holds a token.
+func webhookHost(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func errorString(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// the url may hold a token
+		err = urlErr.Err
+	}
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// logDelivery saves an attempt to deliver a notification for the run.
+func (jr *JobRun) logDelivery(d WebhookDelivery) {
+	if jr.jobRef == nil || jr.jobRef.cfg.DB == nil || jr.LogEntryId == 0 {
+		return
+	}
+	d.RunId = jr.LogEntryId
+	d.Job = jr.Name
+
+	_, err := jr.jobRef.cfg.DB.NamedExec(`
+	INSERT INTO webhook_delivery (run_id, job, webhook, host, attempt, status_code, error, duration, sent_at)
+	VALUES (:run_id, :job, :webhook, :host, :attempt, :status_code, :error, :duration, :sent_at)
+	`, d)
+	if err != nil {
+		jr.jobRef.log.Warn().Str("job", jr.Name).Err(err).Msg("Couldn't save webhook delivery to db.")
+	}
+}
+
+// loadDeliveriesFromDb loads the notifications delivered for a run.
+func (j *JobSpec) loadDeliveriesFromDb(jr *JobRun) error {
+	if j.cfg.DB == nil {
+		return nil
+	}
+	return j.cfg.DB.Select(&jr.Deliveries, `
+	SELECT id, run_id, job, webhook, host, attempt, status_code, error, duration, sent_at
+	FROM webhook_delivery WHERE run_id = ? ORDER BY id`, jr.LogEntryId)
+}
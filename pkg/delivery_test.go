@@ -0,0 +1,180 @@
+package cheek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	var calls atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch {
+		case r.URL.Path == "/gone":
+			http.NotFound(w, r)
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		case n == 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case n == 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = fmt.Fprint(w, "ok")
+		}
+	}))
+	defer testServer.Close()
+
+	status := 0
+	jr := JobRun{Status: &status, Name: "test"}
+
+	target := WebhookTarget{URL: testServer.URL, DeliveryOptions: DeliveryOptions{RetryDelay: time.Millisecond}}
+	resp, err := defaultWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(resp))
+	assert.Equal(t, int32(3), calls.Load())
+
+	// client errors are not retried
+	calls.Store(0)
+	target.URL = testServer.URL + "/gone"
+	_, err = defaultWebhook{target}.Call(&jr)
+	assert.ErrorContains(t, err, "webhook responded with 404 Not Found")
+	assert.Equal(t, int32(1), calls.Load())
+
+	// retries give up eventually
+	calls.Store(0)
+	retries := 1
+	target.URL = testServer.URL + "/down"
+	target.Retries = &retries
+	_, err = defaultWebhook{target}.Call(&jr)
+	assert.ErrorContains(t, err, "500 Internal Server Error")
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer testServer.Close()
+	defer close(release)
+
+	status := 0
+	retries := 0
+	target := WebhookTarget{URL: testServer.URL, DeliveryOptions: DeliveryOptions{Timeout: 50 * time.Millisecond, Retries: &retries}}
+	start := time.Now()
+	_, err := defaultWebhook{target}.Call(&JobRun{Status: &status, Name: "test"})
+	assert.ErrorContains(t, err, "Timeout")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	signatures := make(chan string, 1)
+	bodies := make(chan []byte, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- r.Header.Get(signatureHeader)
+		bodies <- body
+	}))
+	defer testServer.Close()
+
+	status := 0
+	jr := JobRun{Status: &status, Name: "test"}
+
+	target := WebhookTarget{URL: testServer.URL, Secret: "s3cret"}
+	_, err := slackWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	assert.Equal(t, target.sign(<-bodies), <-signatures)
+
+	// no secret, no signature
+	_, err = slackWebhook{WebhookTarget{URL: testServer.URL}}.Call(&jr)
+	assert.NoError(t, err)
+	assert.Equal(t, "", <-signatures)
+	<-bodies
+
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		WebhookTarget{Secret: "key"}.sign([]byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	var calls atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"fail": {
+			Command: []string{"false"},
+			OnError: OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/hooks/token", DeliveryOptions: DeliveryOptions{RetryDelay: time.Millisecond}}}},
+		},
+	})
+	jr := s.Jobs["fail"].run(context.Background(), "test", nil)
+	s.deliveries.Wait()
+
+	router := setupRouter(s)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/jobs/fail/runs/%d", jr.LogEntryId), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got JobRun
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	if assert.Len(t, got.Deliveries, 2) {
+		d := got.Deliveries[0]
+		assert.Equal(t, jr.LogEntryId, d.RunId)
+		assert.Equal(t, "generic", d.Webhook)
+		assert.Equal(t, testServer.Listener.Addr().String(), d.Host, "the path of the url may hold a token and is not stored")
+		assert.Equal(t, http.StatusServiceUnavailable, d.StatusCode)
+		assert.Contains(t, d.Error, "503")
+		assert.Equal(t, 1, got.Deliveries[1].Attempt)
+		assert.Equal(t, http.StatusOK, got.Deliveries[1].StatusCode)
+		assert.Empty(t, got.Deliveries[1].Error)
+	}
+}
+
+func TestWebhookDeliveryInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"fail": {
+			Command:       []string{"false"},
+			OverlapPolicy: OverlapSkip,
+			OnError:       OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+		},
+	})
+	j := s.Jobs["fail"]
+
+	done := make(chan JobRun)
+	go func() {
+		// the second run is not skipped, the slow webhook of the first one
+		// doesn't hold on to the run
+		j.run(context.Background(), "test", nil)
+		done <- j.run(context.Background(), "test", nil)
+	}()
+	select {
+	case jr := <-done:
+		assert.Equal(t, 1, *jr.Status, "the run should not be skipped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("run waited for its notifications to be delivered")
+	}
+
+	close(release)
+	s.deliveries.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,373 @@
+package cheek
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Ways to secure the connection to the SMTP server.
+const (
+	emailTLSStartTLS = "starttls"
+	emailTLSImplicit = "tls"
+	emailTLSNone     = "none"
+)
+
+var emailTLSModes = []string{emailTLSStartTLS, emailTLSImplicit, emailTLSNone}
+
+const (
+	defaultEmailSubject = `[cheek] {{ .Name }} {{ .StatusText }}`
+	defaultEmailBody    = `{{ if eq .StatusText "running" -}}
+{{ if .DurationExceeded }}{{ .Name }} is still running.{{ else }}{{ .Name }} started.{{ end }}
+{{- else if eq .StatusText "missed" -}}
+{{ .Name }} did not run as expected.
+{{- else -}}
+{{ .Name }} finished with status {{ .Status }} ({{ .StatusText }}) after {{ .Duration }}.
+{{- end }}
+Triggered by {{ .TriggeredBy }} at {{ .TriggeredAt.Format "2006-01-02 15:04:05 MST" }}.
+{{ if .URL }}
+{{ .URL }}
+{{ end }}{{ if .LogTail }}
+Last lines of the log:
+
+{{ .LogTail }}
+{{ end }}`
+)
+
+// EmailTarget is a mailbox (or several) to notify through an SMTP server.
+type EmailTarget struct {
+	Host string `yaml:"host" json:"host"`
+	// Port defaults to 587 for starttls, 465 for tls and 25 without TLS.
+	Port     int      `yaml:"port,omitempty" json:"port,omitempty"`
+	TLS      string   `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Username string   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password secret   `yaml:"password,omitempty" json:"password,omitempty"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
+	// Subject and Body are templates, like those of webhooks.
+	Subject         string `yaml:"subject,omitempty" json:"subject,omitempty"`
+	Body            string `yaml:"body,omitempty" json:"body,omitempty"`
+	AttachLog       bool   `yaml:"attach_log,omitempty" json:"attach_log,omitempty"`
+	DeliveryOptions `yaml:",inline"`
+	subjectTmpl     *template.Template
+	bodyTmpl        *template.Template
+}
+
+func (t EmailTarget) tlsMode() string {
+	if t.TLS == "" {
+		return emailTLSStartTLS
+	}
+	return t.TLS
+}
+
+func (t EmailTarget) port() int {
+	if t.Port > 0 {
+		return t.Port
+	}
+	switch t.tlsMode() {
+	case emailTLSImplicit:
+		return 465
+	case emailTLSNone:
+		return 25
+	default:
+		return 587
+	}
+}
+
+func (t EmailTarget) address() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.port()))
+}
+
+// secrets returns what is masked of the target when marshalling.
+func (t *EmailTarget) secrets() []any {
+	return []any{t.Password}
+}
+
+// validate checks the target and parses its templates.
+func (t *EmailTarget) validate() error {
+	if t.Host == "" {
+		return errors.New("email host cannot be empty")
+	}
+	if !slices.Contains(emailTLSModes, t.tlsMode()) {
+		return fmt.Errorf("email tls '%s' of %s should be one of %s", t.TLS, t.Host, strings.Join(emailTLSModes, "|"))
+	}
+	if _, err := mail.ParseAddress(t.From); err != nil {
+		return fmt.Errorf("email from '%s' of %s: %w", t.From, t.Host, err)
+	}
+	if len(t.To) == 0 {
+		return fmt.Errorf("email to of %s cannot be empty", t.Host)
+	}
+	for _, to := range t.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("email to '%s' of %s: %w", to, t.Host, err)
+		}
+	}
+	if err := t.DeliveryOptions.validate(); err != nil {
+		return fmt.Errorf("email %s: %w", t.Host, err)
+	}
+
+	var err error
+	if t.subjectTmpl, err = parseEmailTemplate(t.Subject, defaultEmailSubject); err != nil {
+		return fmt.Errorf("email subject template of %s: %w", t.Host, err)
+	}
+	if t.bodyTmpl, err = parseEmailTemplate(t.Body, defaultEmailBody); err != nil {
+		return fmt.Errorf("email body template of %s: %w", t.Host, err)
+	}
+	return nil
+}
+
+func parseEmailTemplate(text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	return template.New("email").Funcs(webhookFuncs).Option("missingkey=error").Parse(text)
+}
+
+// message renders the email for a run.
+func (t EmailTarget) message(jr *JobRun) ([]byte, error) {
+	data := newWebhookData(jr)
+	var subject, body bytes.Buffer
+	if err := t.subjectTmpl.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("render email subject: %w", err)
+	}
+	if err := t.bodyTmpl.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("render email body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", t.From)
+	header.Set("To", strings.Join(t.To, ", "))
+	// a subject is a single line
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " ")))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+
+	if !t.AttachLog {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeMIMEHeader(&msg, header)
+		if err := writeQuotedPrintable(&msg, body.Bytes()); err != nil {
+			return nil, err
+		}
+		return msg.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&msg)
+	header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	writeMIMEHeader(&msg, header)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(part, body.Bytes()); err != nil {
+		return nil, err
+	}
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": fmt.Sprintf("%s-%d.log", jr.Name, jr.LogEntryId)})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: part})
+	if _, err := enc.Write([]byte(jr.Log)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func writeMIMEHeader(b *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s: %s\r\n", k, header.Get(k))
+	}
+	b.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, body []byte) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(body); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// lineWrapper breaks base64 output into lines of 76 characters, as required
+// for email.
+type lineWrapper struct {
+	w io.Writer
+	n int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), 76-l.n)]
+		if _, err := l.w.Write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		l.n += len(chunk)
+		p = p[len(chunk):]
+		if l.n == 76 {
+			if _, err := l.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+			l.n = 0
+		}
+	}
+	return written, nil
+}
+
+// send delivers msg to the SMTP server, it returns the reply code of the
+// server.
+func (t EmailTarget) send(msg []byte) (int, error) {
+	deadline := time.Now().Add(t.timeout())
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsCfg := &tls.Config{ServerName: t.Host, MinVersion: tls.VersionTLS12}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if t.tlsMode() == emailTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.address(), tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", t.address())
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return 0, err
+	}
+
+	c, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		_ = conn.Close()
+		return smtpCode(err), err
+	}
+	defer func() { _ = c.Close() }()
+
+	if t.tlsMode() == emailTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return 0, fmt.Errorf("smtp server %s does not support STARTTLS", t.Host)
+		}
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return smtpCode(err), err
+		}
+	}
+	if t.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.Username, string(t.Password), t.Host)); err != nil {
+			return smtpCode(err), err
+		}
+	}
+
+	if err := c.Mail(emailAddress(t.From)); err != nil {
+		return smtpCode(err), err
+	}
+	for _, to := range t.To {
+		if err := c.Rcpt(emailAddress(to)); err != nil {
+			return smtpCode(err), err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpCode(err), err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return smtpCode(err), err
+	}
+	// the message is accepted, an error on quit doesn't change that
+	_ = c.Quit()
+	return 250, nil
+}
+
+// emailAddress returns the bare address of e.g. "Cheek <cheek@example.com>".
+func emailAddress(s string) string {
+	if a, err := mail.ParseAddress(s); err == nil {
+		return a.Address
+	}
+	return s
+}
+
+// smtpCode is the reply code of a failed SMTP command, 0 when the server
+// didn't reply.
+func smtpCode(err error) int {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code
+	}
+	return 0
+}
+
+// retryableSMTP reports whether a failed delivery is worth another try: when
+// the server didn't reply, or replied with a transient (4xx) error.
+func retryableSMTP(code int, err error) bool {
+	if code == 0 {
+		return err != nil
+	}
+	return code >= 400 && code < 500
+}
+
+// Email notifier
+
+type emailNotifier struct {
+	target EmailTarget
+}
+
+func (en emailNotifier) Call(jr *JobRun) ([]byte, error) {
+	msg, err := en.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+	d := WebhookDelivery{Webhook: en.Name(), Host: en.target.Host}
+	return en.target.deliver(jr, d, retryableSMTP, func() (int, []byte, error) {
+		code, err := en.target.send(msg)
+		return code, []byte{}, err
+	})
+}
+
+func (en emailNotifier) URL() string {
+	return "smtp://" + en.target.address()
+}
+
+func (en emailNotifier) Name() string {
+	return "email"
+}
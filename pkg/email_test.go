@@ -0,0 +1,298 @@
+package cheek
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// smtpStandIn is a minimal SMTP server that records what it receives.
+type smtpStandIn struct {
+	ln net.Listener
+	// reject replies to MAIL FROM with this code for the first connections
+	reject []int
+
+	mutex    sync.Mutex
+	conns    int
+	auth     []string
+	rcpts    []string
+	messages []string
+}
+
+func newSMTPStandIn(t *testing.T, reject ...int) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, reject: reject}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns++
+			n := s.conns
+			s.mutex.Unlock()
+			go s.serve(conn, n)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve(conn net.Conn, n int) {
+	c := textproto.NewConn(conn)
+	defer func() { _ = c.Close() }()
+	_ = c.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			_ = c.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			s.mutex.Lock()
+			s.auth = append(s.auth, line)
+			s.mutex.Unlock()
+			_ = c.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			if n <= len(s.reject) {
+				_ = c.PrintfLine("%d try again later", s.reject[n-1])
+				continue
+			}
+			_ = c.PrintfLine("250 OK")
+		case "RCPT":
+			s.mutex.Lock()
+			s.rcpts = append(s.rcpts, line)
+			s.mutex.Unlock()
+			_ = c.PrintfLine("250 OK")
+		case "DATA":
+			_ = c.PrintfLine("354 go ahead")
+			msg, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.messages = append(s.messages, string(msg))
+			s.mutex.Unlock()
+			_ = c.PrintfLine("250 OK queued")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestEmailTargetValidate(t *testing.T) {
+	valid := func() EmailTarget {
+		return EmailTarget{Host: "smtp.example.com", From: "Cheek <cheek@example.com>", To: []string{"oncall@example.com"}}
+	}
+	tests := []struct {
+		name    string
+		modify  func(*EmailTarget)
+		wantErr string
+	}{
+		{"valid", func(*EmailTarget) {}, ""},
+		{"no host", func(t *EmailTarget) { t.Host = "" }, "host cannot be empty"},
+		{"tls mode", func(t *EmailTarget) { t.TLS = "ssl" }, "should be one of starttls|tls|none"},
+		{"from", func(t *EmailTarget) { t.From = "nope" }, "email from 'nope'"},
+		{"no to", func(t *EmailTarget) { t.To = nil }, "to of smtp.example.com cannot be empty"},
+		{"to", func(t *EmailTarget) { t.To = []string{"a@example.com", "nope"} }, "email to 'nope'"},
+		{"subject", func(t *EmailTarget) { t.Subject = "{{ .Name" }, "email subject template"},
+		{"retries", func(t *EmailTarget) { r := -1; t.Retries = &r }, "retries cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := valid()
+			tt.modify(&target)
+			err := target.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	target := valid()
+	assert.Equal(t, 587, target.port())
+	target.TLS = emailTLSImplicit
+	assert.Equal(t, 465, target.port())
+	target.Port = 2525
+	assert.Equal(t, 2525, target.port())
+}
+
+func TestEmailNotification(t *testing.T) {
+	srv := newSMTPStandIn(t, 421)
+
+	status := 1
+	jr := JobRun{
+		LogEntryId:  7,
+		Status:      &status,
+		Name:        "backup",
+		TriggeredBy: "cron",
+		Duration:    time.Second,
+		Log:         "starting\ndisk full\n",
+	}
+
+	var e OnEvent
+	err := yaml.Unmarshal([]byte(`
+notify_email:
+  - host: 127.0.0.1
+    port: `+strconv.Itoa(srv.port())+`
+    tls: none
+    username: cheek
+    password: hunter2
+    from: Cheek <cheek@example.com>
+    to: [oncall@example.com, ops@example.com]
+    subject: '{{ .Name }} failed ({{ .Status }})'
+    attach_log: true
+    retry_delay: 1ms
+`), &e)
+	assert.NoError(t, err)
+	assert.NoError(t, e.validate())
+
+	out, err := yaml.Marshal(e)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "hunter2")
+
+	wh := e.webhooks()
+	if !assert.Len(t, wh, 1) {
+		return
+	}
+	assert.Equal(t, "email", wh[0].Name())
+	_, err = wh[0].Call(&jr)
+	assert.NoError(t, err)
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	assert.Equal(t, 2, srv.conns, "the transient error should be retried")
+	if assert.Len(t, srv.auth, 2) {
+		assert.Equal(t, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00cheek\x00hunter2")), srv.auth[1])
+	}
+	assert.Equal(t, []string{"RCPT TO:<oncall@example.com>", "RCPT TO:<ops@example.com>"}, srv.rcpts)
+	if !assert.Len(t, srv.messages, 1) {
+		return
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(srv.messages[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "backup failed (1)", msg.Header.Get("Subject"))
+	assert.Equal(t, "oncall@example.com, ops@example.com", msg.Header.Get("To"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(part)
+	assert.Contains(t, string(body), "backup finished with status 1 (error) after 1s.")
+	assert.Contains(t, string(body), "disk full")
+
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "backup-7.log", part.FileName())
+	attachment, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	assert.Equal(t, jr.Log, string(attachment))
+}
+
+func TestEmailPermanentError(t *testing.T) {
+	srv := newSMTPStandIn(t, 550, 550)
+
+	target := EmailTarget{
+		Host:            "127.0.0.1",
+		Port:            srv.port(),
+		TLS:             emailTLSNone,
+		From:            "cheek@example.com",
+		To:              []string{"oncall@example.com"},
+		DeliveryOptions: DeliveryOptions{RetryDelay: time.Millisecond},
+	}
+	assert.NoError(t, target.validate())
+
+	status := 1
+	_, err := emailNotifier{target}.Call(&JobRun{Status: &status, Name: "backup"})
+	assert.ErrorContains(t, err, "550")
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	assert.Equal(t, 1, srv.conns, "permanent errors should not be retried")
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	srv := newSMTPStandIn(t)
+
+	retries := 0
+	target := EmailTarget{
+		Host:            "127.0.0.1",
+		Port:            srv.port(),
+		From:            "cheek@example.com",
+		To:              []string{"oncall@example.com"},
+		DeliveryOptions: DeliveryOptions{Retries: &retries},
+	}
+	assert.NoError(t, target.validate())
+
+	status := 1
+	_, err := emailNotifier{target}.Call(&JobRun{Status: &status, Name: "backup"})
+	assert.ErrorContains(t, err, "does not support STARTTLS")
+}
+
+func TestEmailDeliveries(t *testing.T) {
+	srv := newSMTPStandIn(t)
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"fail": {
+			Command: []string{"false"},
+			OnError: OnEvent{NotifyEmail: []EmailTarget{{
+				Host: "127.0.0.1",
+				Port: srv.port(),
+				TLS:  emailTLSNone,
+				From: "cheek@example.com",
+				To:   []string{"oncall@example.com"},
+			}}},
+		},
+	})
+	jr := s.Jobs["fail"].run(context.Background(), "test", nil)
+	s.deliveries.Wait()
+
+	var got JobRun
+	got.LogEntryId = jr.LogEntryId
+	assert.NoError(t, s.Jobs["fail"].loadDeliveriesFromDb(&got))
+	if assert.Len(t, got.Deliveries, 1) {
+		assert.Equal(t, "email", got.Deliveries[0].Webhook)
+		assert.Equal(t, "127.0.0.1", got.Deliveries[0].Host)
+		assert.Equal(t, 250, got.Deliveries[0].StatusCode)
+	}
+}
@@ -9,19 +9,24 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"gopkg.in/yaml.v3"
 )
 
 type TemplateData struct {
 	Name string
+	Auth bool
 }
 type Response struct {
-	Job    string `json:"jobs,omitempty"`
-	Status string `json:"status,omitempty"`
-	Type   string `json:"type,omitempty"`
+	Job       string  `json:"jobs,omitempty"`
+	Status    string  `json:"status,omitempty"`
+	Type      string  `json:"type,omitempty"`
+	RunId     int     `json:"run_id,omitempty"`
+	StatusURL string  `json:"status_url,omitempty"`
+	Run       *JobRun `json:"run,omitempty"`
 }
 
 // This will be injected at build time
@@ -35,10 +40,31 @@ type VersionResponse struct {
 	CommitSHA string `json:"commit_sha"`
 }
 
+// JobResponse is a job along with its state at the time of the request, the
+// state is read once so the job can change while the response is encoded.
+type JobResponse struct {
+	*JobSpec
+	Runs        []JobRun   `json:"runs"`
+	Running     int        `json:"running"`
+	Queued      int        `json:"queued"`
+	NextTick    *time.Time `json:"next_tick,omitempty"`
+	NextTickUTC *time.Time `json:"next_tick_utc,omitempty"`
+	Yaml        string     `json:"yaml,omitempty"`
+}
+
+func newJobResponse(j *JobSpec) JobResponse {
+	resp := JobResponse{JobSpec: j, Runs: j.runs()}
+	resp.Running, resp.Queued = j.overlapState()
+	resp.NextTick, resp.NextTickUTC = j.nextTicks()
+	return resp
+}
+
 type ScheduleStatusResponse struct {
 	Status         map[string]int `json:"status,omitempty"`
 	FailedRunCount int            `json:"failed_run_count,omitempty"`
 	HasFailedRuns  bool           `json:"has_failed_runs,omitempty"`
+	Missed         []string       `json:"missed,omitempty"`
+	HasMissedRuns  bool           `json:"has_missed_runs,omitempty"`
 }
 
 //go:embed web_assets
@@ -57,19 +83,26 @@ func setupRouter(s *Schedule) *httprouter.Router {
 	router := httprouter.New()
 
 	// ui endpoints
-	router.GET("/jobs/:jobId/:jobRunId", getJobDetailPage(s))
-	router.GET("/core/logs", getCoreLogsPage())
-	router.GET("/", getHomePage())
+	router.GET("/jobs/:jobId/:jobRunId", authorizePage(s, getJobDetailPage(s)))
+	router.GET("/core/logs", authorizePage(s, getCoreLogsPage(s)))
+	router.GET("/login", getLoginPage())
+	router.POST("/login", postLogin(s))
+	router.POST("/logout", postLogout(s))
+	router.GET("/", authorizePage(s, getHomePage(s)))
 
 	// api endpoints
 	router.GET("/healthz/", getHealthCheck)
-	router.GET("/api/jobs", getJobs(s))
-	router.GET("/api/jobs/:jobId", getJob(s))
-	router.GET("/api/jobs/:jobId/runs/:jobRunId", getJobRun(s))
-	router.POST("/api/jobs/:jobId/trigger", postTrigger(s))
-	router.GET("/api/core/logs", getCoreLogs(s))
-	router.GET("/api/schedule/status", getScheduleStatus(s))
-	router.GET("/api/version", getVersion) // Add version endpoint
+	router.GET("/api/jobs", authorize(s, ScopeRead, getJobs(s)))
+	router.GET("/api/jobs/:jobId", authorize(s, ScopeRead, getJob(s)))
+	router.GET("/api/jobs/:jobId/runs/:jobRunId", authorize(s, ScopeRead, getJobRun(s)))
+	router.GET("/api/jobs/:jobId/runs/:jobRunId/lineage", authorize(s, ScopeRead, getJobRunLineage(s)))
+	router.POST("/api/jobs/:jobId/runs/:jobRunId/cancel", authorize(s, ScopeCancel, postCancel(s)))
+	router.GET("/api/jobs/:jobId/runs/:jobRunId/stream", authorize(s, ScopeRead, getJobRunStream(s)))
+	router.POST("/api/jobs/:jobId/trigger", authorize(s, ScopeTrigger, postTrigger(s)))
+	router.GET("/api/core/logs", authorize(s, ScopeRead, getCoreLogs(s)))
+	router.GET("/api/schedule/status", authorize(s, ScopeRead, getScheduleStatus(s)))
+	router.GET("/api/version", authorize(s, ScopeRead, getVersion)) // Add version endpoint
+	router.GET("/metrics", authorize(s, ScopeRead, getMetrics(s)))
 
 	fileServer := http.FileServer(http.FS(fsys()))
 	router.GET("/static/*filepath", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -79,14 +112,14 @@ func setupRouter(s *Schedule) *httprouter.Router {
 	return router
 }
 
-func getCoreLogsPage() httprouter.Handle {
+func getCoreLogsPage(s *Schedule) httprouter.Handle {
 	tmpl, err := template.ParseFS(fsys(), "templates/corelogs.html", "templates/base.html")
 	if err != nil {
 		panic(err)
 	}
 
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		err := tmpl.ExecuteTemplate(w, "base.html", nil)
+		err := tmpl.ExecuteTemplate(w, "base.html", TemplateData{Auth: s.authEnabled()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -94,7 +127,7 @@ func getCoreLogsPage() httprouter.Handle {
 	}
 }
 
-func getHomePage() httprouter.Handle {
+func getHomePage(s *Schedule) httprouter.Handle {
 
 	tmpl, err := template.ParseFS(fsys(), "templates/overview.html", "templates/base.html")
 	if err != nil {
@@ -102,7 +135,7 @@ func getHomePage() httprouter.Handle {
 	}
 
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		err := tmpl.ExecuteTemplate(w, "base.html", nil)
+		err := tmpl.ExecuteTemplate(w, "base.html", TemplateData{Auth: s.authEnabled()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -118,13 +151,13 @@ func getJobDetailPage(s *Schedule) httprouter.Handle {
 
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		jobId := ps.ByName("jobId")
-		_, ok := s.Jobs[jobId]
+		_, ok := s.getJob(jobId)
 		if !ok {
 			http.Error(w, fmt.Errorf("job %s not found", jobId).Error(), http.StatusNotFound)
 			return
 		}
 
-		err := tmpl.ExecuteTemplate(w, "base.html", nil)
+		err := tmpl.ExecuteTemplate(w, "base.html", TemplateData{Auth: s.authEnabled()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -132,14 +165,6 @@ func getJobDetailPage(s *Schedule) httprouter.Handle {
 	}
 }
 
-func server(s *Schedule) {
-	httpAddr := fmt.Sprintf(":%s", s.cfg.Port)
-	router := setupRouter(s)
-
-	s.log.Info().Msgf("Starting HTTP server on %v", httpAddr)
-	log.Fatal(http.ListenAndServe(httpAddr, router))
-}
-
 func getHealthCheck(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	status := Response{Status: "ok"}
 	w.Header().Set("Content-Type", "application/json")
@@ -151,11 +176,14 @@ func getHealthCheck(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 func getJobs(s *Schedule) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
-		for _, j := range s.Jobs {
+		jobs := s.jobs()
+		resp := make(map[string]JobResponse, len(jobs))
+		for name, j := range jobs {
 			j.loadRunsFromDb(20, false)
+			resp[name] = newJobResponse(j)
 		}
 
-		if err := json.NewEncoder(w).Encode(s.Jobs); err != nil {
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -165,12 +193,19 @@ func getScheduleStatus(s *Schedule) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		w.Header().Set("Content-Type", "application/json")
 
+		jobs := s.jobs()
 		ssr := ScheduleStatusResponse{
-			Status: make(map[string]int, len(s.Jobs)),
+			Status: make(map[string]int, len(jobs)),
 		}
 
-		for _, j := range s.Jobs {
+		for _, j := range jobs {
+			if j.isMissed() {
+				ssr.Missed = append(ssr.Missed, j.Name)
+			}
 			j.loadRunsFromDb(1, false)
+			if len(j.Runs) == 0 || j.Runs[0].Status == nil {
+				continue // never ran or still running
+			}
 			lastRunStatus := j.Runs[0].Status
 			ssr.Status[j.Name] = *lastRunStatus
 			if *lastRunStatus == 1 {
@@ -178,7 +213,9 @@ func getScheduleStatus(s *Schedule) httprouter.Handle {
 			}
 		}
 
+		sort.Strings(ssr.Missed)
 		ssr.HasFailedRuns = ssr.FailedRunCount > 0
+		ssr.HasMissedRuns = len(ssr.Missed) > 0
 
 		if err := json.NewEncoder(w).Encode(ssr); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -204,7 +241,7 @@ func getCoreLogs(s *Schedule) httprouter.Handle {
 func getJob(s *Schedule) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		jobId := ps.ByName("jobId")
-		job, ok := s.Jobs[jobId]
+		job, ok := s.getJob(jobId)
 
 		if !ok {
 			status := Response{Job: jobId, Status: "error: can't find job to get runs", Type: "runs"}
@@ -217,7 +254,7 @@ func getJob(s *Schedule) httprouter.Handle {
 		}
 
 		// convert job to YAML
-		jobYaml, err := yaml.Marshal(job)
+		jobYaml, err := job.marshalSpec()
 		if err != nil {
 			status := Response{Job: jobId, Status: "error: can't convert job to yaml", Type: "yaml"}
 			w.Header().Set("Content-Type", "application/json")
@@ -231,10 +268,11 @@ func getJob(s *Schedule) httprouter.Handle {
 		// get job runs from db
 		job.loadRunsFromDb(50, false)
 
-		job.Yaml = string(jobYaml)
+		resp := newJobResponse(job)
+		resp.Yaml = string(jobYaml)
 
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(job); err != nil {
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
@@ -247,7 +285,7 @@ func getJobRun(s *Schedule) httprouter.Handle {
 		runId := ps.ByName("jobRunId")
 
 		runIdInt, err := strconv.Atoi(runId)
-		job, ok := s.Jobs[jobId]
+		job, ok := s.getJob(jobId)
 
 		if !ok || err != nil {
 			status := Response{Job: jobId, Status: "error: can't find job / id to get runs", Type: "runs"}
@@ -270,6 +308,13 @@ func getJobRun(s *Schedule) httprouter.Handle {
 			return
 		}
 
+		if err := job.loadAttemptsFromDb(&jr); err != nil {
+			job.log.Warn().Str("job", job.Name).Err(err).Msg("Couldn't load run attempts from db.")
+		}
+		if err := job.loadDeliveriesFromDb(&jr); err != nil {
+			job.log.Warn().Str("job", job.Name).Err(err).Msg("Couldn't load webhook deliveries from db.")
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(jr); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -277,10 +322,131 @@ func getJobRun(s *Schedule) httprouter.Handle {
 	}
 }
 
+func getJobRunLineage(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		jobId := ps.ByName("jobId")
+		runId := ps.ByName("jobRunId")
+
+		runIdInt, err := strconv.Atoi(runId)
+		_, ok := s.getJob(jobId)
+
+		if !ok || err != nil || s.cfg.DB == nil {
+			status := Response{Job: jobId, Status: "error: can't find job / id to get lineage", Type: "lineage"}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		rl, err := loadRunLineage(s.cfg.DB, runIdInt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rl); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// sseKeepAlive is how often a comment is sent on an idle event stream, to
+// keep proxies from closing it.
+const sseKeepAlive = 15 * time.Second
+
+func getJobRunStream(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		jobId := ps.ByName("jobId")
+		runId := ps.ByName("jobRunId")
+
+		runIdInt, err := strconv.Atoi(runId)
+		job, ok := s.getJob(jobId)
+
+		var jr JobRun
+		if ok && err == nil {
+			jr, err = job.loadLogFromDb(runIdInt)
+		}
+
+		if !ok || err != nil || jr.Name != job.Name {
+			status := Response{Job: jobId, Status: "error: can't find job / id to stream", Type: "stream"}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// stream the output of a run in progress, or what was saved of a
+		// finished one
+		b, live := s.live.get(jr.LogEntryId)
+		if !live {
+			b = new(tsBuffer)
+			_, _ = b.Write([]byte(jr.Log))
+			_ = b.Close()
+		}
+
+		clearWriteDeadline(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+
+		offset := 0
+		for {
+			data, change, closed := b.since(offset)
+			offset += len(data)
+			if len(data) > 0 {
+				writeEvent(w, "log", map[string]string{"log": string(data)})
+			}
+			if closed {
+				if live {
+					jr, _ = job.loadLogFromDb(jr.LogEntryId)
+				}
+				writeEvent(w, "end", map[string]*int{"status": jr.Status})
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+
+			select {
+			case <-change:
+			case <-keepAlive.C:
+				_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a server-sent event with a JSON payload.
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
 func postTrigger(s *Schedule) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		jobId := ps.ByName("jobId")
-		job, ok := s.Jobs[jobId]
+		job, ok := s.getJob(jobId)
 
 		if !ok {
 			status := Response{Job: jobId, Status: "error: can't find job to trigger", Type: "trigger"}
@@ -292,10 +458,85 @@ func postTrigger(s *Schedule) httprouter.Handle {
 			return
 		}
 
-		job.execCommandWithRetry(context.Background(), "ui", nil) // trigger
+		var timeout time.Duration
+		wait := r.URL.Query().Get("wait") == "true"
+		if t := r.URL.Query().Get("timeout"); wait && t != "" {
+			var err error
+			if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+				status := Response{Job: jobId, Status: "error: timeout should be a positive duration, e.g. 30s", Type: "trigger"}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				if err := json.NewEncoder(w).Encode(status); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		// set up the run so its id is known, then execute it in the background
+		// as the run should not depend on the request
+		jr := job.setup("ui", nil)
+		done := make(chan JobRun, 1)
+		go func() {
+			done <- job.execRun(context.Background(), jr, "ui")
+		}()
+
+		status := Response{Job: jobId, Status: "ok", Type: "trigger", RunId: jr.LogEntryId}
+		if jr.LogEntryId != 0 {
+			status.StatusURL = fmt.Sprintf("/api/jobs/%s/runs/%d", jobId, jr.LogEntryId)
+		}
+
+		code := http.StatusAccepted
+		if wait {
+			clearWriteDeadline(w)
+			var expired <-chan time.Time
+			if timeout > 0 {
+				timer := time.NewTimer(timeout)
+				defer timer.Stop()
+				expired = timer.C
+			}
+
+			select {
+			case finished := <-done:
+				status.Run = &finished
+				code = http.StatusOK
+			case <-expired:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func postCancel(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		jobId := ps.ByName("jobId")
+		runId := ps.ByName("jobRunId")
+
+		runIdInt, err := strconv.Atoi(runId)
+		job, ok := s.getJob(jobId)
+
+		if !ok || err != nil || !s.CancelRun(job, runIdInt) {
+			status := Response{Job: jobId, Status: "error: can't find run in progress to cancel", Type: "cancel"}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			if err := json.NewEncoder(w).Encode(status); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		s.log.Info().Str("job", jobId).Int("run_id", runIdInt).Msg("Run cancelled on request")
 
-		status := Response{Job: jobId, Status: "ok", Type: "trigger"}
+		status := Response{Job: jobId, Status: "ok", Type: "cancel", RunId: runIdInt, StatusURL: fmt.Sprintf("/api/jobs/%s/runs/%d", jobId, runIdInt)}
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
 		if err := json.NewEncoder(w).Encode(status); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -1,12 +1,17 @@
 package cheek
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMux(t *testing.T) {
@@ -137,7 +142,7 @@ func TestMux(t *testing.T) {
 		},
 		{
 			schedule: &s2,
-			name:     "/trigger/ must return 200",
+			name:     "/trigger/ must return 202",
 			args: func(*testing.T) args {
 				req, err := http.NewRequest("POST", "/api/jobs/bertha/trigger", nil)
 				if err != nil {
@@ -147,9 +152,39 @@ func TestMux(t *testing.T) {
 					req: req,
 				}
 			},
-			wantCode: http.StatusOK,
+			wantCode: http.StatusAccepted,
 			wantBody: "\"status\":\"ok\",\"type\":\"trigger\"",
 		},
+		{
+			schedule: &s2,
+			name:     "/trigger/?wait=true must return 200 with the run",
+			args: func(*testing.T) args {
+				req, err := http.NewRequest("POST", "/api/jobs/bertha/trigger?wait=true&timeout=10s", nil)
+				if err != nil {
+					t.Fatalf("fail to create request: %s", err.Error())
+				}
+				return args{
+					req: req,
+				}
+			},
+			wantCode: http.StatusOK,
+			wantBody: "\"run\":{\"status\":0",
+		},
+		{
+			schedule: &s2,
+			name:     "/trigger/ with invalid timeout must return 400",
+			args: func(*testing.T) args {
+				req, err := http.NewRequest("POST", "/api/jobs/bertha/trigger?wait=true&timeout=soon", nil)
+				if err != nil {
+					t.Fatalf("fail to create request: %s", err.Error())
+				}
+				return args{
+					req: req,
+				}
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: "error: timeout",
+		},
 		{
 			schedule: &s1,
 			name:     "/ must return 200 with html content",
@@ -216,3 +251,68 @@ func TestMux(t *testing.T) {
 		})
 	}
 }
+
+func TestTriggerAsync(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"slow": {Command: []string{"sleep", "0.5"}},
+	})
+	handler := setupRouter(s)
+
+	// the run is started in the background
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/api/jobs/slow/trigger", nil))
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	var r Response
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &r))
+	assert.NotZero(t, r.RunId)
+	assert.Equal(t, fmt.Sprintf("/api/jobs/slow/runs/%d", r.RunId), r.StatusURL)
+
+	jr, err := s.Jobs["slow"].loadLogFromDb(r.RunId)
+	assert.NoError(t, err)
+	assert.Nil(t, jr.Status, "run should still be in progress")
+
+	// waiting shorter than the run takes still returns the run id
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/api/jobs/slow/trigger?wait=true&timeout=50ms", nil))
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.Contains(t, resp.Body.String(), "\"run_id\":")
+
+	// waiting longer returns the finished run
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("POST", "/api/jobs/slow/trigger?wait=true", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &r))
+	assert.Equal(t, StatusOK, *r.Run.Status)
+	assert.Equal(t, r.RunId, r.Run.LogEntryId)
+
+	assert.Eventually(t, func() bool {
+		var running int
+		_ = s.cfg.DB.Get(&running, "SELECT COUNT(*) FROM log WHERE job = 'slow' AND status IS NULL")
+		return running == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestTriggerAsyncConcurrent(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"quick": {Command: []string{"true"}},
+	})
+	handler := setupRouter(s)
+
+	// without a db the runs are kept in memory, as they finish
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest("POST", "/api/jobs/quick/trigger", nil))
+			assert.Equal(t, http.StatusAccepted, resp.Code)
+		}()
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool {
+		return len(s.Jobs["quick"].runs()) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,131 @@
+package cheek
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adhocore/gronx"
+)
+
+// Kinds of schedule a job can have, which are also the triggers of the runs
+// they start.
+const (
+	ScheduleCron  = "cron"
+	ScheduleEvery = "every"
+	ScheduleAt    = "at"
+)
+
+// minEvery is the shortest interval, the scheduler ticks once a second.
+const minEvery = time.Second
+
+// scheduleKind returns the kind of schedule of the job, empty if it only runs
+// when triggered.
+func (j *JobSpec) scheduleKind() string {
+	switch {
+	case j.Cron != "":
+		return ScheduleCron
+	case j.Every > 0:
+		return ScheduleEvery
+	case j.At != nil:
+		return ScheduleAt
+	}
+	return ""
+}
+
+// ValidateCron checks the schedule of the job.
+//
+// Deprecated: jobs can be scheduled by more than cron, use ValidateSchedule.
+func (j *JobSpec) ValidateCron() error {
+	return j.ValidateSchedule()
+}
+
+// ValidateSchedule checks the cron, every or at schedule of the job.
+func (j *JobSpec) ValidateSchedule() error {
+	kinds := 0
+	for _, set := range []bool{j.Cron != "", j.Every != 0, j.At != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return fmt.Errorf("job '%s' can only have one of cron, every and at", j.Name)
+	}
+
+	if j.Cron != "" {
+		gronx := gronx.New()
+		if !gronx.IsValid(j.Cron) {
+			return fmt.Errorf("cron string for job '%s' not valid", j.Name)
+		}
+	}
+
+	if j.Every < 0 {
+		return fmt.Errorf("every for job '%s' cannot be negative", j.Name)
+	}
+	if j.Every != 0 && j.Every < minEvery {
+		return fmt.Errorf("every for job '%s' should be at least %v", j.Name, minEvery)
+	}
+	if (j.EveryAlign || j.EveryOffset != 0) && j.Every == 0 {
+		return fmt.Errorf("every_align and every_offset for job '%s' require every", j.Name)
+	}
+	if j.EveryOffset < 0 || (j.Every > 0 && j.EveryOffset >= j.Every) {
+		return fmt.Errorf("every_offset for job '%s' should be at least 0 and less than every", j.Name)
+	}
+
+	if j.At != nil && j.At.IsZero() {
+		return fmt.Errorf("at for job '%s' cannot be empty", j.Name)
+	}
+	return nil
+}
+
+// nextInterval returns the first tick of an every schedule after ref, or at
+// ref with inclRefTime. Aligned ticks fall on multiples of every since the
+// Unix epoch, shifted by every_offset. Other ticks follow on from the
+// previous one, the first one is every after ref.
+func (j *JobSpec) nextInterval(ref time.Time, inclRefTime bool) time.Time {
+	if j.EveryAlign || j.EveryOffset > 0 {
+		start := time.Unix(0, 0).Add(j.EveryOffset)
+		t := start.Add(ref.Sub(start) / j.Every * j.Every)
+		if t.Before(ref) || (t.Equal(ref) && !inclRefTime) {
+			t = t.Add(j.Every)
+		}
+		return t.In(j.location())
+	}
+
+	t := j.scheduledTick()
+	if t.IsZero() {
+		return ref.Add(j.Every)
+	}
+	if !t.After(ref) {
+		// skip the ticks that passed, e.g. while the host was suspended
+		t = t.Add((ref.Sub(t)/j.Every + 1) * j.Every)
+	}
+	return t
+}
+
+// oneShotFired reports whether the at schedule of the job has fired, in this
+// process or, as recorded in the db, before a restart.
+func (j *JobSpec) oneShotFired() bool {
+	if j.fired || j.cfg.DB == nil {
+		return j.fired
+	}
+	var n int
+	if err := j.cfg.DB.Get(&n, "SELECT COUNT(*) FROM one_shot WHERE job = ? AND at = ?", j.Name, j.At.UTC()); err != nil {
+		j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't load one-shot state from db.")
+		return false
+	}
+	j.fired = n > 0
+	return j.fired
+}
+
+// markOneShotFired records that the at schedule of the job fired, so it won't
+// fire again. This is done before the run starts, a run that is cut off by a
+// restart is not repeated.
+func (j *JobSpec) markOneShotFired(at time.Time) {
+	j.fired = true
+	if j.cfg.DB == nil {
+		return
+	}
+	if _, err := j.cfg.DB.Exec("INSERT OR IGNORE INTO one_shot (job, at, fired_at) VALUES (?, ?, ?)", j.Name, j.At.UTC(), at); err != nil {
+		j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't save one-shot state to db.")
+	}
+}
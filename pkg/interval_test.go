@@ -0,0 +1,119 @@
+package cheek
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	at := time.Date(2026, time.November, 1, 3, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		job     *JobSpec
+		wantErr string
+	}{
+		{"cron", &JobSpec{Cron: "* * * * *"}, ""},
+		{"every", &JobSpec{Every: 90 * time.Second}, ""},
+		{"aligned every", &JobSpec{Every: time.Hour, EveryAlign: true, EveryOffset: 15 * time.Minute}, ""},
+		{"at", &JobSpec{At: &at}, ""},
+		{"only triggered", &JobSpec{}, ""},
+		{"invalid cron", &JobSpec{Cron: "INVALID"}, "cron string for job 'job' not valid"},
+		{"cron and every", &JobSpec{Cron: "* * * * *", Every: time.Minute}, "only have one of cron, every and at"},
+		{"every and at", &JobSpec{Every: time.Minute, At: &at}, "only have one of cron, every and at"},
+		{"negative every", &JobSpec{Every: -time.Minute}, "cannot be negative"},
+		{"every too short", &JobSpec{Every: 500 * time.Millisecond}, "should be at least 1s"},
+		{"offset without every", &JobSpec{EveryOffset: time.Minute}, "require every"},
+		{"offset too large", &JobSpec{Every: time.Minute, EveryOffset: time.Minute}, "less than every"},
+		{"empty at", &JobSpec{At: &time.Time{}}, "cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.job.Name = "job"
+			err := tt.job.ValidateSchedule()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleKindsYAML(t *testing.T) {
+	var j JobSpec
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+command: date
+every: 90s
+every_offset: 30s
+`), &j))
+	assert.Equal(t, 90*time.Second, j.Every)
+	assert.Equal(t, 30*time.Second, j.EveryOffset)
+	assert.Equal(t, ScheduleEvery, j.scheduleKind())
+
+	j = JobSpec{}
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+command: date
+at: 2026-11-01T03:00:00Z
+`), &j))
+	if assert.NotNil(t, j.At) {
+		assert.Equal(t, time.Date(2026, time.November, 1, 3, 0, 0, 0, time.UTC), j.At.UTC())
+	}
+	assert.Equal(t, ScheduleAt, j.scheduleKind())
+}
+
+func TestNextInterval(t *testing.T) {
+	ref := time.Date(2026, time.March, 1, 10, 7, 30, 0, time.UTC)
+
+	// aligned to multiples of every, shifted by the offset
+	j := &JobSpec{Every: 15 * time.Minute, EveryAlign: true}
+	assert.Equal(t, time.Date(2026, time.March, 1, 10, 15, 0, 0, time.UTC), j.nextInterval(ref, false).UTC())
+	j.EveryOffset = 5 * time.Minute
+	assert.Equal(t, time.Date(2026, time.March, 1, 10, 20, 0, 0, time.UTC), j.nextInterval(ref, false).UTC())
+	onTick := time.Date(2026, time.March, 1, 10, 20, 0, 0, time.UTC)
+	assert.Equal(t, onTick, j.nextInterval(onTick, true).UTC())
+	assert.Equal(t, onTick.Add(15*time.Minute), j.nextInterval(onTick, false).UTC())
+
+	// unaligned ticks start from the first time they are set and keep their pace
+	j = &JobSpec{Every: 90 * time.Second}
+	assert.NoError(t, j.setNextTick(ref, true))
+	first := ref.Add(90 * time.Second)
+	assert.Equal(t, first, j.nextTick)
+	assert.NoError(t, j.setNextTick(first.Add(800*time.Millisecond), false))
+	assert.Equal(t, first.Add(90*time.Second), j.nextTick)
+
+	// ticks that passed are skipped
+	assert.NoError(t, j.setNextTick(first.Add(10*time.Minute), false))
+	assert.Equal(t, first.Add(630*time.Second), j.nextTick)
+}
+
+func TestOneShot(t *testing.T) {
+	at := time.Date(2026, time.November, 1, 3, 0, 0, 0, time.UTC)
+	past := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"once":   {Command: []string{"true"}, At: &at},
+		"missed": {Command: []string{"true"}, At: &past},
+	})
+	once := s.Jobs["once"]
+	assert.Equal(t, at, once.nextTick.UTC())
+	// a one-shot that passed while cheek wasn't running is still due
+	assert.Equal(t, past, s.Jobs["missed"].nextTick.UTC())
+
+	// once fired, it has no next tick
+	once.markOneShotFired(at.Add(time.Second))
+	assert.NoError(t, once.setNextTick(at.Add(time.Second), false))
+	assert.True(t, once.nextTick.IsZero())
+
+	// not even after a restart
+	restarted := &JobSpec{Name: "once", Command: []string{"true"}, At: &at, cfg: s.cfg, log: s.log, globalSchedule: s}
+	assert.NoError(t, restarted.setNextTick(at.Add(time.Hour), true))
+	assert.True(t, restarted.nextTick.IsZero())
+
+	// moving it to another time schedules it again
+	later := at.Add(24 * time.Hour)
+	moved := &JobSpec{Name: "once", Command: []string{"true"}, At: &later, cfg: s.cfg, log: s.log, globalSchedule: s}
+	assert.NoError(t, moved.setNextTick(at.Add(time.Hour), true))
+	assert.Equal(t, later, moved.nextTick.UTC())
+}
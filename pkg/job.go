@@ -8,51 +8,118 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"reflect"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/adhocore/gronx"
 	"github.com/rs/zerolog"
 	"gopkg.in/yaml.v3"
 )
 
 // Global status constants
 const (
-	StatusOK    int = 0
-	StatusError int = -1
+	StatusOK        int = 0
+	StatusError     int = -1
+	StatusTimeout   int = -2
+	StatusSkipped   int = -3
+	StatusReplaced  int = -4
+	StatusCancelled int = -5
+	StatusMissed    int = -6
 )
 
+// Catch-up policies for cron ticks that were missed while cheek was not running.
+const (
+	CatchupNone = "none"
+	CatchupLast = "last"
+	CatchupAll  = "all"
+)
+
+const (
+	// defaultCatchupWindow is how far back missed ticks are looked for
+	// when no catchup_window is configured.
+	defaultCatchupWindow = 24 * time.Hour
+	// maxCatchupRuns caps the number of replayed ticks per job.
+	maxCatchupRuns = 100
+)
+
+// defaultKillGracePeriod is the time a job's process group gets between
+// SIGTERM and SIGKILL when no kill_grace_period is configured.
+const defaultKillGracePeriod = 10 * time.Second
+
 // OnEvent contains specs on what needs to happen after a job event.
 type OnEvent struct {
-	TriggerJob           []string `yaml:"trigger_job,omitempty" json:"trigger_job,omitempty"`
-	NotifyWebhook        []string `yaml:"notify_webhook,omitempty" json:"notify_webhook,omitempty"`
-	NotifySlackWebhook   []string `yaml:"notify_slack_webhook,omitempty" json:"notify_slack_webhook,omitempty"`
-	NotifyDiscordWebhook []string `yaml:"notify_discord_webhook,omitempty" json:"notify_discord_webhook,omitempty"`
+	TriggerJob              []string        `yaml:"trigger_job,omitempty" json:"trigger_job,omitempty"`
+	NotifyWebhook           []WebhookTarget `yaml:"notify_webhook,omitempty" json:"notify_webhook,omitempty"`
+	NotifySlackWebhook      []WebhookTarget `yaml:"notify_slack_webhook,omitempty" json:"notify_slack_webhook,omitempty"`
+	NotifyDiscordWebhook    []WebhookTarget `yaml:"notify_discord_webhook,omitempty" json:"notify_discord_webhook,omitempty"`
+	NotifyTeamsWebhook      []WebhookTarget `yaml:"notify_teams_webhook,omitempty" json:"notify_teams_webhook,omitempty"`
+	NotifyMattermostWebhook []WebhookTarget `yaml:"notify_mattermost_webhook,omitempty" json:"notify_mattermost_webhook,omitempty"`
+	NotifyGoogleChatWebhook []WebhookTarget `yaml:"notify_google_chat_webhook,omitempty" json:"notify_google_chat_webhook,omitempty"`
+	NotifyNtfy              []WebhookTarget `yaml:"notify_ntfy,omitempty" json:"notify_ntfy,omitempty"`
+	NotifyGotify            []WebhookTarget `yaml:"notify_gotify,omitempty" json:"notify_gotify,omitempty"`
+	NotifyEmail             []EmailTarget   `yaml:"notify_email,omitempty" json:"notify_email,omitempty"`
 }
 
 // JobSpec holds specifications and metadata of a job.
 type JobSpec struct {
-	Yaml string `yaml:"-" json:"yaml,omitempty"`
-
-	Cron    string      `yaml:"cron,omitempty" json:"cron,omitempty"`
-	Command stringArray `yaml:"command" json:"command"`
-
-	OnSuccess         OnEvent `yaml:"on_success,omitempty" json:"on_success,omitempty"`
-	OnError           OnEvent `yaml:"on_error,omitempty" json:"on_error,omitempty"`
+	Cron        string        `yaml:"cron,omitempty" json:"cron,omitempty"`
+	Every       time.Duration `yaml:"every,omitempty" json:"every,omitempty"`
+	EveryAlign  bool          `yaml:"every_align,omitempty" json:"every_align,omitempty"`
+	EveryOffset time.Duration `yaml:"every_offset,omitempty" json:"every_offset,omitempty"`
+	At          *time.Time    `yaml:"at,omitempty" json:"at,omitempty"`
+	TZLocation  string        `yaml:"tz_location,omitempty" json:"tz_location,omitempty"`
+	Command     stringArray   `yaml:"command" json:"command"`
+
+	OnSuccess          OnEvent `yaml:"on_success,omitempty" json:"on_success,omitempty"`
+	OnError            OnEvent `yaml:"on_error,omitempty" json:"on_error,omitempty"`
 	OnRetriesExhausted OnEvent `yaml:"on_retries_exhausted,omitempty" json:"on_retries_exhausted,omitempty"`
+	OnTimeout          OnEvent `yaml:"on_timeout,omitempty" json:"on_timeout,omitempty"`
+	OnCancel           OnEvent `yaml:"on_cancel,omitempty" json:"on_cancel,omitempty"`
+	OnStart            OnEvent `yaml:"on_start,omitempty" json:"on_start,omitempty"`
+	OnRecovery         OnEvent `yaml:"on_recovery,omitempty" json:"on_recovery,omitempty"`
+	OnMissed           OnEvent `yaml:"on_missed,omitempty" json:"on_missed,omitempty"`
+	OnDurationExceeded OnEvent `yaml:"on_duration_exceeded,omitempty" json:"on_duration_exceeded,omitempty"`
+	NotifyOn           string  `yaml:"notify_on,omitempty" json:"notify_on,omitempty"`
+	AlertAfterFailures int     `yaml:"alert_after_failures,omitempty" json:"alert_after_failures,omitempty"`
 
 	Name                       string            `json:"name"`
 	Retries                    int               `yaml:"retries,omitempty" json:"retries,omitempty"`
+	RetryDelay                 time.Duration     `yaml:"retry_delay,omitempty" json:"retry_delay,omitempty"`
+	RetryBackoff               string            `yaml:"retry_backoff,omitempty" json:"retry_backoff,omitempty"`
+	RetryMaxDelay              time.Duration     `yaml:"retry_max_delay,omitempty" json:"retry_max_delay,omitempty"`
+	RetryJitter                float64           `yaml:"retry_jitter,omitempty" json:"retry_jitter,omitempty"`
+	RetryOnExitCodes           []int             `yaml:"retry_on_exit_codes,omitempty" json:"retry_on_exit_codes,omitempty"`
+	NoRetryOnExitCodes         []int             `yaml:"no_retry_on_exit_codes,omitempty" json:"no_retry_on_exit_codes,omitempty"`
 	Env                        map[string]secret `yaml:"env,omitempty"`
 	WorkingDirectory           string            `yaml:"working_directory,omitempty" json:"working_directory,omitempty"`
 	DisableConcurrentExecution bool              `yaml:"disable_concurrent_execution,omitempty" json:"disable_concurrent_execution,omitempty"`
+	Timeout                    time.Duration     `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	KillGracePeriod            time.Duration     `yaml:"kill_grace_period,omitempty" json:"kill_grace_period,omitempty"`
+	WarnAfter                  time.Duration     `yaml:"warn_after,omitempty" json:"warn_after,omitempty"`
+	Catchup                    string            `yaml:"catchup,omitempty" json:"catchup,omitempty"`
+	CatchupWindow              time.Duration     `yaml:"catchup_window,omitempty" json:"catchup_window,omitempty"`
+	ExpectRunWithin            time.Duration     `yaml:"expect_run_within,omitempty" json:"expect_run_within,omitempty"`
+	MaxStaleness               time.Duration     `yaml:"max_staleness,omitempty" json:"max_staleness,omitempty"`
+	DependsOn                  []string          `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+	DependsOnCondition         string            `yaml:"depends_on_condition,omitempty" json:"depends_on_condition,omitempty"`
+	DependsOnWindow            time.Duration     `yaml:"depends_on_window,omitempty" json:"depends_on_window,omitempty"`
+	OverlapPolicy              string            `yaml:"overlap_policy,omitempty" json:"overlap_policy,omitempty"`
+	OverlapQueueDepth          int               `yaml:"overlap_queue_depth,omitempty" json:"overlap_queue_depth,omitempty"`
+	Retention                  RetentionPolicy   `yaml:"retention,omitempty" json:"retention,omitempty"`
 	globalSchedule             *Schedule
 	Runs                       []JobRun `json:"runs" yaml:"-"`
 
 	nextTick time.Time
 	log      zerolog.Logger
 	cfg      Config
-	mutex    sync.Mutex
+	mutex    sync.Mutex // guards Runs, nextTick and overlap
+	overlap  *overlapGuard
+	deps     dependencyCycle
+	missed   missedState
+	loc      *time.Location
+	fired    bool
+	spec     []byte
 }
 
 type secret string
@@ -64,38 +131,59 @@ func (secret) MarshalText() ([]byte, error) {
 
 // JobRun holds information about a job execution.
 type JobRun struct {
-	LogEntryId        int     `json:"id,omitempty" db:"id"`
-	Status            *int    `json:"status,omitempty" db:"status,omitempty"`
-	logBuf            bytes.Buffer
-	Log               string        `json:"log" db:"message"`
-	Name              string        `json:"name" db:"job"`
-	TriggeredAt       time.Time     `json:"triggered_at" db:"triggered_at"`
-	TriggeredBy       string        `json:"triggered_by" db:"triggered_by,omitempty"`
-	TriggeredByJobRun *JobRun       `json:"triggered_by_job_run,omitempty"`
-	Triggered         []string      `json:"triggered,omitempty"`
-	Duration          time.Duration `json:"duration,omitempty" db:"duration"`
-	RetryAttempt      int           `json:"retry_attempt,omitempty"`
-	RetriesExhausted  bool          `json:"retries_exhausted,omitempty"`
+	LogEntryId        int  `json:"id,omitempty" db:"id"`
+	Status            *int `json:"status,omitempty" db:"status,omitempty"`
+	logBuf            *tsBuffer
+	Log               string            `json:"log" db:"message"`
+	Name              string            `json:"name" db:"job"`
+	TriggeredAt       time.Time         `json:"triggered_at" db:"triggered_at"`
+	TriggeredBy       string            `json:"triggered_by" db:"triggered_by,omitempty"`
+	TriggeredByJobRun *JobRun           `json:"triggered_by_job_run,omitempty"`
+	DependencyRuns    []*JobRun         `json:"dependency_runs,omitempty"`
+	Triggered         []string          `json:"triggered,omitempty"`
+	Duration          time.Duration     `json:"duration,omitempty" db:"duration"`
+	RetryAttempt      int               `json:"retry_attempt,omitempty" db:"attempt"`
+	RetriesExhausted  bool              `json:"retries_exhausted,omitempty" db:"retries_exhausted"`
+	DurationExceeded  bool              `json:"duration_exceeded,omitempty" db:"duration_exceeded"`
+	ParentRunId       int               `json:"parent_run_id,omitempty" db:"parent_run_id"`
+	TriggerRunId      int               `json:"trigger_run_id,omitempty" db:"trigger_run_id"`
+	Attempts          []JobRun          `json:"attempts,omitempty"`
+	Deliveries        []WebhookDelivery `json:"deliveries,omitempty"`
 	jobRef            *JobSpec
+	retrying          bool // the attempt failed and is retried
 }
 
 func (jr *JobRun) flushLogBuffer() {
-	jr.Log = jr.logBuf.String()
+	jr.Log = jr.logs().String()
+}
+
+// logs returns the buffer the output of the run is written to.
+func (jr *JobRun) logs() *tsBuffer {
+	if jr.logBuf == nil {
+		jr.logBuf = new(tsBuffer)
+	}
+	return jr.logBuf
 }
 
-func (j *JobSpec) setup(trigger string, parentJobRun *JobRun) JobRun {
+func (j *JobSpec) setup(trigger string, parentJobRun *JobRun, dependencyRuns ...*JobRun) JobRun {
 	// Initialize the JobRun before executing the command
 	jr := JobRun{
 		Name:              j.Name,
 		TriggeredAt:       j.now(),
 		TriggeredBy:       trigger,
 		TriggeredByJobRun: parentJobRun,
+		DependencyRuns:    dependencyRuns,
 		Status:            nil,
 		jobRef:            j,
 	}
+	if parentJobRun != nil {
+		jr.TriggerRunId = parentJobRun.LogEntryId
+	}
 
 	// Log the job run immediately to the database to mark the job as started
 	jr.logToDb()
+	jr.logLineage()
+	j.trackLog(&jr)
 
 	return jr
 }
@@ -106,19 +194,36 @@ func (jr *JobRun) logToDb() {
 		return
 	}
 
-	// Perform an UPSERT (insert or update)
-	_, err := jr.jobRef.cfg.DB.Exec(`
-		INSERT INTO log (job,triggered_at ,triggered_by, duration, status, message) 
-		VALUES (?, ?, ?, ?, ?, ?)
+	var err error
+	if jr.LogEntryId != 0 {
+		// Update the row of a run that was already logged when it started
+		_, err = jr.jobRef.cfg.DB.Exec(`
+		UPDATE log SET
+			duration = ?,
+			status = ?,
+			message = ?,
+			retries_exhausted = ?,
+			duration_exceeded = ?
+		WHERE id = ?
+		`,
+			jr.Duration, jr.Status, jr.Log, jr.RetriesExhausted, jr.DurationExceeded, jr.LogEntryId)
+	} else {
+		// Perform an UPSERT (insert or update)
+		err = jr.jobRef.cfg.DB.Get(&jr.LogEntryId, `
+		INSERT INTO log (job, triggered_at, triggered_by, duration, status, message, attempt, parent_run_id, retries_exhausted, trigger_run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(job, triggered_at, triggered_by) DO UPDATE SET 
 			duration = excluded.duration, 
 			status = excluded.status, 
-			message = excluded.message;
+			message = excluded.message
+		RETURNING id
 		`,
-		jr.Name, jr.TriggeredAt, jr.TriggeredBy, jr.Duration, jr.Status, jr.Log)
+			jr.Name, jr.TriggeredAt, jr.TriggeredBy, jr.Duration, jr.Status, jr.Log, jr.RetryAttempt, jr.ParentRunId, jr.RetriesExhausted, jr.TriggerRunId)
+	}
 
 	if err != nil {
 		if jr.jobRef.globalSchedule != nil {
+			jr.jobRef.globalSchedule.metrics.observeDbWriteError()
 			jr.jobRef.globalSchedule.log.Warn().Str("job", jr.Name).Err(err).Msg("Couldn't save job log to db.")
 		} else {
 			panic(err)
@@ -131,27 +236,32 @@ func (j *JobSpec) finalize(jr *JobRun) {
 	jr.flushLogBuffer()
 	// write logs to disk
 	jr.logToDb()
+	// end the live stream of the output
+	j.untrackLog(jr)
 	// if no DB, store run in memory for testing/debugging
 	if j.cfg.DB == nil {
-		j.Runs = append(j.Runs, *jr)
+		j.addRun(*jr)
 	}
 	// launch on_events
 	j.OnEvent(jr)
 }
 
-func (j *JobSpec) execCommandWithRetry(ctx context.Context, trigger string, parentJobRun *JobRun) JobRun {
-	tries := 0
-	var jr JobRun
-	const timeOut = 5 * time.Second
-
+func (j *JobSpec) execCommandWithRetry(ctx context.Context, trigger string, parentJobRun *JobRun, dependencyRuns ...*JobRun) JobRun {
 	// Initialize the JobRun with the first trigger
-	jr = j.setup(trigger, parentJobRun)
+	jr := j.setup(trigger, parentJobRun, dependencyRuns...)
+
+	return j.execWithRetry(ctx, jr, trigger)
+}
+
+// execWithRetry executes a job run that has been set up, retrying it on failure.
+func (j *JobSpec) execWithRetry(ctx context.Context, jr JobRun, trigger string) JobRun {
+	tries := 0
 
 	for tries < j.Retries+1 {
 		// Check if context is cancelled before starting
 		if ctx.Err() != nil {
-			jr.Log = "Job cancelled due to scheduler shutdown"
-			exitCode := StatusError
+			exitCode, reason := cancelStatus(ctx)
+			_, _ = fmt.Fprintf(jr.logs(), "Job cancelled %s\n", reason)
 			jr.Status = &exitCode
 			j.finalize(&jr)
 			return jr
@@ -165,49 +275,87 @@ func (j *JobSpec) execCommandWithRetry(ctx context.Context, trigger string, pare
 			// First attempt with the original trigger
 			jr = j.execCommand(ctx, jr, trigger)
 		default:
-			// On retries, update the trigger with retry count and rerun
+			// On retries, record a new attempt and rerun with the retry count in the trigger
+			jr = j.nextAttempt(jr, tries)
 			jr = j.execCommand(ctx, jr, fmt.Sprintf("%s[retry=%d]", trigger, tries))
 		}
 
 		// Finalize logging, etc.
+		jr.retrying = j.willRetry(ctx, jr, tries)
 		j.finalize(&jr)
+		jr.retrying = false
+		j.observeRun(&jr, true)
 
 		if *jr.Status == StatusOK {
 			// Exit if the job succeeded (Status 0)
 			break
 		}
 
+		if ctx.Err() != nil {
+			// Don't retry a run that was killed on purpose
+			break
+		}
+
 		// Increment the attempt counter
 		tries++
 
 		// Check if we have more retries left before sleeping
 		if tries < j.Retries+1 {
+			if !j.shouldRetry(*jr.Status) {
+				j.log.Debug().Str("job", j.Name).Int("exitcode", *jr.Status).Msg("job exited with a non retryable exit code, not retrying.")
+				break
+			}
+
 			// Log the unsuccessful attempt and retry
-			j.log.Debug().Str("job", j.Name).Int("exitcode", *jr.Status).Msgf("job exited unsuccessfully, launching retry after %v timeout.", timeOut)
+			if m := j.metrics(); m != nil {
+				m.observeRetry(j.Name)
+			}
+			delay := j.retryDelay(tries)
+			j.log.Debug().Str("job", j.Name).Int("exitcode", *jr.Status).Msgf("job exited unsuccessfully, launching retry after %v timeout.", delay)
 
 			// Sleep with context cancellation check
 			select {
-			case <-time.After(timeOut):
+			case <-time.After(delay):
 				// Continue to retry
 			case <-ctx.Done():
-				jr.Log += "\nJob cancelled during retry timeout"
-				exitCode := StatusError
+				exitCode, reason := cancelStatus(ctx)
+				_, _ = fmt.Fprintf(jr.logs(), "\nJob cancelled %s during retry timeout\n", reason)
 				jr.Status = &exitCode
+				jr.flushLogBuffer()
+				jr.logToDb()
+				if exitCode == StatusCancelled {
+					j.OnEvent(&jr)
+				}
 				return jr
 			}
 		}
 	}
 
-	// Check if retries were exhausted (retries > 0 and final status is error)
-	if j.Retries > 0 && *jr.Status != StatusOK {
+	// Check if retries were exhausted (retries > 0 and all attempts failed)
+	if j.Retries > 0 && tries == j.Retries+1 {
 		jr.RetriesExhausted = true
+		jr.logToDb()
 		j.log.Debug().Str("job", j.Name).Msg("All retries exhausted, triggering on_retries_exhausted events")
 		j.OnRetriesExhaustedEvent(&jr)
 	}
 
+	// Let jobs that depend on this one know it finished
+	j.notifyDependents(&jr)
+
 	return jr
 }
 
+// cancelStatus maps the reason a run was cancelled to its status and a
+// description for the job log.
+func cancelStatus(ctx context.Context) (int, string) {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errRunReplaced):
+		return StatusReplaced, "as it was replaced by a newer run"
+	case errors.Is(cause, errRunCancelled):
+		return StatusCancelled, "on request"
+	}
+	return StatusError, "due to scheduler shutdown"
+}
 
 func (j *JobSpec) now() time.Time {
 	// defer for if schedule doesn't exist, allows for easy testing
@@ -219,11 +367,10 @@ func (j *JobSpec) now() time.Time {
 
 func (j *JobSpec) execCommand(ctx context.Context, jr JobRun, trigger string) JobRun {
 	j.log.Info().Str("job", j.Name).Str("trigger", trigger).Msgf("Job triggered")
+	j.markRan(j.now())
 	suppressLogs := j.cfg.SuppressLogs
 
-	var cmd *exec.Cmd
-	switch len(j.Command) {
-	case 0:
+	if len(j.Command) == 0 {
 		err := errors.New("no command specified")
 		jr.Log = fmt.Sprintf("Job unable to start: %v", err.Error())
 		j.log.Warn().Str("job", j.Name).Str("trigger", trigger).Err(err).Msg(jr.Log)
@@ -234,12 +381,32 @@ func (j *JobSpec) execCommand(ctx context.Context, jr JobRun, trigger string) Jo
 		jr.Status = &errStatus // Set failure status when no command is specified
 
 		return jr
-	case 1:
-		cmd = exec.CommandContext(ctx, j.Command[0])
-	default:
-		cmd = exec.CommandContext(ctx, j.Command[0], j.Command[1:]...)
 	}
 
+	// bound the runtime of the job if a timeout is set
+	runCtx := ctx
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(runCtx, j.Command[0], j.Command[1:]...)
+
+	// run the job in its own process group so that a kill reaches
+	// all of its children, not just the direct process
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	gracePeriod := j.killGracePeriod()
+	var killTimer *time.Timer
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		killTimer = time.AfterFunc(gracePeriod, func() {
+			_ = syscall.Kill(pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(pgid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = gracePeriod
+
 	// Add env vars
 	cmd.Env = os.Environ()
 	for k, v := range j.Env {
@@ -251,9 +418,9 @@ func (j *JobSpec) execCommand(ctx context.Context, jr JobRun, trigger string) Jo
 	var w io.Writer
 	switch j.cfg.SuppressLogs {
 	case true:
-		w = &jr.logBuf
+		w = jr.logs()
 	default:
-		w = io.MultiWriter(os.Stdout, &jr.logBuf)
+		w = io.MultiWriter(os.Stdout, jr.logs())
 	}
 
 	// Merge stdout and stderr to same writer
@@ -283,42 +450,72 @@ func (j *JobSpec) execCommand(ctx context.Context, jr JobRun, trigger string) Jo
 		return jr
 	}
 
-	// Wait for the command to finish and check for errors
-	if err := cmd.Wait(); err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			// Check if it was killed due to context cancellation
-			if ctx.Err() != nil {
-				jr.Log += "\nJob killed due to scheduler shutdown"
-				exitCode := StatusError
-				jr.Status = &exitCode
-				j.log.Info().Str("job", j.Name).Msg("Job killed due to context cancellation")
-			} else {
-				// Get the exact exit code from ExitError
-				exitCode := exitError.ExitCode()
-				jr.Status = &exitCode // Set the exit code in the job result
-				j.log.Warn().Str("job", j.Name).Msgf("Exit code: %d", exitCode)
-				jr.Log += fmt.Sprintf("Exit code: %d\n", exitCode)
-			}
-		} else {
-			// Handle unexpected errors
-			exitCode := StatusError
-			j.log.Error().Str("job", j.Name).Err(err).Msg("unexpected error during command execution")
-			jr.Status = &exitCode
-			return jr
-		}
-	} else {
+	// Wait for the command to finish and check for errors, saving its
+	// output along the way
+	stopCheckpoints := j.checkpointLogs(&jr)
+	stopWatchdog := j.watchDuration(&jr)
+	err = cmd.Wait()
+	// the duration includes the time the run waited for its turn
+	jr.Duration = time.Duration(time.Since(jr.TriggeredAt).Milliseconds())
+	jr.DurationExceeded = stopWatchdog()
+	stopCheckpoints()
+	if killTimer != nil {
+		killTimer.Stop()
+	}
+	// Check if it was killed due to a timeout or context cancellation first, a
+	// job that traps SIGTERM can still exit cleanly and Wait returns
+	// exec.ErrWaitDelay when its output stays open after the kill
+	var exitError *exec.ExitError
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		exitCode := StatusTimeout
+		jr.Status = &exitCode
+		j.log.Warn().Str("job", j.Name).Str("trigger", trigger).Msgf("Job timed out after %v", j.Timeout)
+		_, _ = fmt.Fprintf(w, "\nJob timed out after %v\n", j.Timeout)
+	case ctx.Err() != nil:
+		exitCode, reason := cancelStatus(ctx)
+		_, _ = fmt.Fprintf(w, "\nJob killed %s\n", reason)
+		jr.Status = &exitCode
+		j.log.Info().Str("job", j.Name).Msg("Job killed due to context cancellation")
+	case err == nil:
 		// No error, command exited successfully
 		StatusCode := StatusOK
 		jr.Status = &StatusCode // Command succeeded, set exit code 0
+	case errors.As(err, &exitError):
+		// Get the exact exit code from ExitError
+		exitCode := exitError.ExitCode()
+		jr.Status = &exitCode // Set the exit code in the job result
+		j.log.Warn().Str("job", j.Name).Msgf("Exit code: %d", exitCode)
+		jr.Log += fmt.Sprintf("Exit code: %d\n", exitCode)
+	default:
+		// Handle unexpected errors
+		exitCode := StatusError
+		j.log.Error().Str("job", j.Name).Err(err).Msg("unexpected error during command execution")
+		jr.Status = &exitCode
+		return jr
 	}
 
-	jr.Duration = time.Duration(time.Since(jr.TriggeredAt).Milliseconds())
-
 	j.log.Debug().Str("job", j.Name).Int("exitcode", *jr.Status).Msgf("job exited with status: %d", *jr.Status)
 
 	return jr
 }
 
+func (j *JobSpec) killGracePeriod() time.Duration {
+	if j.KillGracePeriod > 0 {
+		return j.KillGracePeriod
+	}
+	return defaultKillGracePeriod
+}
+
+func (j *JobSpec) ValidateTimeout() error {
+	if j.Timeout < 0 {
+		return fmt.Errorf("timeout for job '%s' cannot be negative", j.Name)
+	}
+	if j.KillGracePeriod < 0 {
+		return fmt.Errorf("kill_grace_period for job '%s' cannot be negative", j.Name)
+	}
+	return nil
+}
 
 func (j *JobSpec) loadLogFromDb(id int) (JobRun, error) {
 	var jr JobRun
@@ -329,7 +526,7 @@ func (j *JobSpec) loadLogFromDb(id int) (JobRun, error) {
 
 	// if id -1 then load last run
 	if id == -1 {
-		err := j.cfg.DB.Get(&jr, "SELECT id, triggered_at, triggered_by, duration, status, message FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT 1", j.Name)
+		err := j.cfg.DB.Get(&jr, "SELECT id, job, triggered_at, triggered_by, duration, status, message, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT 1", j.Name)
 		if err != nil {
 			j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't load job run from db.")
 			return jr, err
@@ -337,7 +534,7 @@ func (j *JobSpec) loadLogFromDb(id int) (JobRun, error) {
 		return jr, nil
 	}
 
-	err := j.cfg.DB.Get(&jr, "SELECT id, triggered_at, triggered_by, duration, status, message FROM log WHERE id = ?", id)
+	err := j.cfg.DB.Get(&jr, "SELECT id, job, triggered_at, triggered_by, duration, status, message, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded FROM log WHERE id = ?", id)
 	if err != nil {
 		j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't load job run from db.")
 		return jr, err
@@ -345,6 +542,20 @@ func (j *JobSpec) loadLogFromDb(id int) (JobRun, error) {
 	return jr, nil
 }
 
+// loadAttemptsFromDb loads all attempts of the logical run a run belongs to.
+func (j *JobSpec) loadAttemptsFromDb(jr *JobRun) error {
+	if j.cfg.DB == nil {
+		return errors.New("no db connection")
+	}
+
+	runId := jr.LogEntryId
+	if jr.ParentRunId != 0 {
+		runId = jr.ParentRunId
+	}
+
+	return j.cfg.DB.Select(&jr.Attempts, "SELECT id, triggered_at, triggered_by, duration, status, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded FROM log WHERE id = ? OR parent_run_id = ? ORDER BY attempt", runId, runId)
+}
+
 func (j *JobSpec) loadRunsFromDb(nruns int, includeLogs bool) {
 	var query string
 	if j.cfg.DB == nil {
@@ -352,9 +563,9 @@ func (j *JobSpec) loadRunsFromDb(nruns int, includeLogs bool) {
 		return
 	}
 	if includeLogs {
-		query = "SELECT id, triggered_at, triggered_by, duration, status, message FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT ?"
+		query = "SELECT id, triggered_at, triggered_by, duration, status, message, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT ?"
 	} else {
-		query = "SELECT id, triggered_at, triggered_by, duration, status FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT ?"
+		query = "SELECT id, triggered_at, triggered_by, duration, status, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded FROM log WHERE job = ? ORDER BY triggered_at DESC LIMIT ?"
 	}
 	rows, err := j.cfg.DB.Query(query, j.Name, nruns)
 	if err != nil {
@@ -369,26 +580,105 @@ func (j *JobSpec) loadRunsFromDb(nruns int, includeLogs bool) {
 		j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't load job runs from db.")
 		return
 	}
+	j.mutex.Lock()
 	j.Runs = jrs
+	j.mutex.Unlock()
+}
+
+// addRun keeps a run in memory, runs of a job can finish concurrently.
+func (j *JobSpec) addRun(jr JobRun) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.Runs = append(j.Runs, jr)
+}
+
+// runs returns a copy of the runs kept in memory.
+func (j *JobSpec) runs() []JobRun {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return append([]JobRun(nil), j.Runs...)
 }
 
 func (j *JobSpec) setNextTick(refTime time.Time, includeRefTime bool) error {
-	if j.Cron != "" {
-		t, err := gronx.NextTickAfter(j.Cron, refTime, includeRefTime)
-		j.nextTick = t
-		return err
+	var next time.Time
+	var err error
+	switch j.scheduleKind() {
+	case ScheduleCron:
+		next, err = nextTickIn(j.Cron, refTime.In(j.location()), includeRefTime)
+	case ScheduleEvery:
+		next = j.nextInterval(refTime, includeRefTime)
+	case ScheduleAt:
+		// a one-shot that passed while cheek wasn't running still fires
+		if !j.oneShotFired() {
+			next = j.At.In(j.location())
+		}
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.nextTick = next
+	return err
+}
+
+// scheduledTick returns the next tick of the job, zero when it has none.
+func (j *JobSpec) scheduledTick() time.Time {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.nextTick
+}
+
+func (j *JobSpec) ValidateCatchup() error {
+	switch j.Catchup {
+	case "", CatchupNone, CatchupLast, CatchupAll:
+	default:
+		return fmt.Errorf("catchup policy '%s' for job '%s' not valid, should be one of %s|%s|%s", j.Catchup, j.Name, CatchupNone, CatchupLast, CatchupAll)
+	}
+	if j.CatchupWindow < 0 {
+		return fmt.Errorf("catchup_window for job '%s' cannot be negative", j.Name)
+	}
+	if j.Catchup != "" && j.Catchup != CatchupNone && j.Cron == "" {
+		return fmt.Errorf("catchup policy for job '%s' requires a cron string", j.Name)
 	}
 	return nil
 }
 
-func (j *JobSpec) ValidateCron() error {
-	if j.Cron != "" {
-		gronx := gronx.New()
-		if !gronx.IsValid(j.Cron) {
-			return fmt.Errorf("cron string for job '%s' not valid", j.Name)
+func (j *JobSpec) catchupWindow() time.Duration {
+	if j.CatchupWindow > 0 {
+		return j.CatchupWindow
+	}
+	return defaultCatchupWindow
+}
+
+// lastScheduledRun returns the time of the last run that was started by the
+// scheduler itself, either on a cron tick or while catching up.
+func (j *JobSpec) lastScheduledRun() (time.Time, error) {
+	var t time.Time
+	if j.cfg.DB == nil {
+		return t, errors.New("no db connection")
+	}
+	err := j.cfg.DB.Get(&t, "SELECT triggered_at FROM log WHERE job = ? AND triggered_by IN ('cron', 'catchup') ORDER BY triggered_at DESC LIMIT 1", j.Name)
+	return t, err
+}
+
+// missedTicks lists the cron ticks after since and before until, limited to
+// the job's catchup window. The cron string is read in the location of since.
+func (j *JobSpec) missedTicks(since, until time.Time) ([]time.Time, error) {
+	if start := until.Add(-j.catchupWindow()); since.Before(start) {
+		since = start.In(since.Location())
+	}
+
+	var ticks []time.Time
+	for {
+		t, err := nextTickIn(j.Cron, since, false)
+		if err != nil {
+			return nil, err
 		}
+		if !t.Before(until) {
+			return ticks, nil
+		}
+		ticks = append(ticks, t)
+		since = t
 	}
-	return nil
 }
 
 func (j *JobSpec) OnEvent(jr *JobRun) {
@@ -396,127 +686,222 @@ func (j *JobSpec) OnEvent(jr *JobRun) {
 	var webhooksToCall []webhook
 	var events []OnEvent
 
-	switch *jr.Status == StatusOK {
-	case true: // after success
+	// a replaced run was stopped on purpose, no need to act on it
+	if *jr.Status == StatusReplaced {
+		return
+	}
+
+	switch {
+	case *jr.Status == StatusCancelled: // after a user cancelled the run
+		events = append(events, j.OnCancel)
+		if s := j.globalSchedule; s != nil {
+			events = append(events, s.event(&s.OnCancel))
+		}
+	case *jr.Status == StatusOK: // after success
 		events = append(events, j.OnSuccess)
-		if j.globalSchedule != nil {
-			events = append(events, j.globalSchedule.OnSuccess)
+		if s := j.globalSchedule; s != nil {
+			events = append(events, s.event(&s.OnSuccess))
 		}
-	case false: // after error
+	default: // after error
 		events = append(events, j.OnError)
-		if j.globalSchedule != nil {
-			events = append(events, j.globalSchedule.OnError)
+		if s := j.globalSchedule; s != nil {
+			events = append(events, s.event(&s.OnError))
+		}
+	}
+
+	if *jr.Status == StatusTimeout { // after timeout, on top of the error events
+		events = append(events, j.OnTimeout)
+		if s := j.globalSchedule; s != nil {
+			events = append(events, s.event(&s.OnTimeout))
 		}
 	}
 
+	// notifications can be limited to changes in the health of the job,
+	// jobs are triggered regardless. When they are, a run with retries is
+	// judged once, on its last attempt.
+	notify, recovered := false, false
+	if !jr.retrying || !j.limitsNotifications() {
+		notify, recovered = j.alertState(jr)
+		if !notify {
+			j.log.Debug().Str("job", j.Name).Str("notify_on", j.notifyOn()).Int("alert_after_failures", j.alertAfterFailures()).Msg("notifications suppressed")
+		}
+	}
 	for _, e := range events {
 		jobsToTrigger = append(jobsToTrigger, e.TriggerJob...)
-		for _, whURL := range e.NotifyWebhook {
-			webhooksToCall = append(webhooksToCall, NewDefaultWebhook(whURL))
+		if notify {
+			webhooksToCall = append(webhooksToCall, e.webhooks()...)
 		}
-		for _, whURL := range e.NotifySlackWebhook {
-			webhooksToCall = append(webhooksToCall, NewSlackWebhook(whURL))
+	}
+
+	if recovered { // after the first success of a failing job
+		events = []OnEvent{j.OnRecovery}
+		if s := j.globalSchedule; s != nil {
+			events = append(events, s.event(&s.OnRecovery))
 		}
-		for _, whURL := range e.NotifyDiscordWebhook {
-			webhooksToCall = append(webhooksToCall, NewDiscordWebhook(whURL))
+		for _, e := range events {
+			jobsToTrigger = append(jobsToTrigger, e.TriggerJob...)
+			webhooksToCall = append(webhooksToCall, e.webhooks()...)
 		}
 	}
 
 	var wg sync.WaitGroup
 
-	for _, tn := range jobsToTrigger {
-		tj := j.globalSchedule.Jobs[tn]
-		j.log.Debug().Str("job", j.Name).Str("on_event", "job_trigger").Msg("triggered by parent job")
-		wg.Add(1)
-		go func(wg *sync.WaitGroup, tj *JobSpec) {
-			defer wg.Done()
-			if tj.DisableConcurrentExecution {
-				tj.mutex.Lock()
-				defer tj.mutex.Unlock()
-			}
-			// Use background context for triggered jobs (they should complete independently)
-			tj.execCommandWithRetry(context.Background(), fmt.Sprintf("job[%s]", j.Name), jr)
-		}(&wg, tj)
-	}
+	j.triggerJobs(&wg, jr, jobsToTrigger, fmt.Sprintf("job[%s]", j.Name), "job_trigger")
 
 	// trigger webhooks
-	for _, wu := range webhooksToCall {
-		j.log.Debug().Str("job", j.Name).Str("on_event", wu.Name()+"_webhook_call").Msg("triggered by parent job")
-		wg.Add(1)
-		go func(wg *sync.WaitGroup, wu webhook) {
-			defer wg.Done()
-			resp_body, err := wu.Call(jr)
-			if err != nil {
-				j.log.Warn().Str("job", j.Name).Str("on_event", "webhook").Err(err).Msg("webhook notify failed")
-			}
-			j.log.Debug().Str("job", jr.Name).Str("webhook_call", "response").Str("webhook_url", wu.URL()).Msg(string(resp_body))
-		}(&wg, wu)
-	}
+	j.callWebhooks(&wg, jr, webhooksToCall, "webhook")
 
 	wg.Wait() // this allows to wait for go routines when running just the job exec
 }
 
 func (j *JobSpec) OnRetriesExhaustedEvent(jr *JobRun) {
+	j.fireEvents(jr, "retries_exhausted", j.OnRetriesExhausted, func(s *Schedule) *OnEvent { return &s.OnRetriesExhausted })
+}
+
+// fireEvents triggers the jobs and calls the webhooks of an event of the job
+// and the same event of the schedule, and waits for the triggered jobs. The
+// name of the event labels the runs it triggers and the logs.
+func (j *JobSpec) fireEvents(jr *JobRun, name string, event OnEvent, scheduleEvent func(s *Schedule) *OnEvent) {
 	var jobsToTrigger []string
 	var webhooksToCall []webhook
-	var events []OnEvent
 
-	// Add on_retries_exhausted events
-	events = append(events, j.OnRetriesExhausted)
-	if j.globalSchedule != nil {
-		events = append(events, j.globalSchedule.OnRetriesExhausted)
+	events := []OnEvent{event}
+	if s := j.globalSchedule; s != nil {
+		events = append(events, s.event(scheduleEvent(s)))
 	}
 
 	for _, e := range events {
 		jobsToTrigger = append(jobsToTrigger, e.TriggerJob...)
-		for _, whURL := range e.NotifyWebhook {
-			webhooksToCall = append(webhooksToCall, NewDefaultWebhook(whURL))
-		}
-		for _, whURL := range e.NotifySlackWebhook {
-			webhooksToCall = append(webhooksToCall, NewSlackWebhook(whURL))
-		}
-		for _, whURL := range e.NotifyDiscordWebhook {
-			webhooksToCall = append(webhooksToCall, NewDiscordWebhook(whURL))
-		}
+		webhooksToCall = append(webhooksToCall, e.webhooks()...)
 	}
 
 	var wg sync.WaitGroup
 
-	for _, tn := range jobsToTrigger {
-		tj := j.globalSchedule.Jobs[tn]
-		j.log.Debug().Str("job", j.Name).Str("on_event", "retries_exhausted_job_trigger").Msg("triggered by retries exhausted")
+	j.triggerJobs(&wg, jr, jobsToTrigger, fmt.Sprintf("%s[%s]", name, j.Name), name+"_job_trigger")
+
+	// trigger webhooks
+	j.callWebhooks(&wg, jr, webhooksToCall, name+"_webhook")
+
+	wg.Wait() // this allows to wait for go routines when running just the job exec
+}
+
+// startEvent fires the on_start events of a run that is about to start in
+// the background, tracked by wg so they don't hold up the run.
+func (j *JobSpec) startEvent(wg *sync.WaitGroup, jr JobRun) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		j.OnStartEvent(&jr)
+	}()
+}
+
+// OnStartEvent fires the on_start events of a run that is about to start.
+func (j *JobSpec) OnStartEvent(jr *JobRun) {
+	j.fireEvents(jr, "start", j.OnStart, func(s *Schedule) *OnEvent { return &s.OnStart })
+}
+
+// triggerJobs runs each of the jobs in the background with jr as their
+// parent, event names the event in the logs.
+func (j *JobSpec) triggerJobs(wg *sync.WaitGroup, jr *JobRun, jobs []string, trigger string, event string) {
+	for _, tn := range jobs {
+		tj, ok := j.globalSchedule.getJob(tn)
+		if !ok {
+			j.log.Warn().Str("job", j.Name).Str("on_event", event).Msgf("cannot find job '%s' to trigger", tn)
+			continue
+		}
+		j.log.Debug().Str("job", j.Name).Str("on_event", event).Msgf("triggering job '%s'", tn)
 		wg.Add(1)
-		go func(wg *sync.WaitGroup, tj *JobSpec) {
+		go func(tj *JobSpec) {
 			defer wg.Done()
-			if tj.DisableConcurrentExecution {
-				tj.mutex.Lock()
-				defer tj.mutex.Unlock()
-			}
 			// Use background context for triggered jobs (they should complete independently)
-			tj.execCommandWithRetry(context.Background(), fmt.Sprintf("retries_exhausted[%s]", j.Name), jr)
-		}(&wg, tj)
+			tj.run(context.Background(), trigger, jr)
+		}(tj)
 	}
+}
 
-	// trigger webhooks
-	for _, wu := range webhooksToCall {
-		j.log.Debug().Str("job", j.Name).Str("on_event", wu.Name()+"_retries_exhausted_webhook_call").Msg("triggered by retries exhausted")
+// callWebhooks calls each of the webhooks in the background, event names the
+// event in the logs.
+func (j *JobSpec) callWebhooks(wg *sync.WaitGroup, jr *JobRun, webhooks []webhook, event string) {
+	if len(webhooks) == 0 {
+		return
+	}
+	// deliveries are tracked by the schedule rather than the run, so a
+	// webhook that is retried doesn't hold up the job
+	if s := j.globalSchedule; s != nil {
+		wg = &s.deliveries
+	}
+	run := *jr // the run carries on while its notifications are delivered
+	for _, wu := range webhooks {
+		j.log.Debug().Str("job", j.Name).Str("on_event", event).Str("notifier", wu.Name()).Msg("calling webhook")
 		wg.Add(1)
-		go func(wg *sync.WaitGroup, wu webhook) {
+		go func(wu webhook) {
 			defer wg.Done()
-			resp_body, err := wu.Call(jr)
+			resp_body, err := wu.Call(&run)
 			if err != nil {
-				j.log.Warn().Str("job", j.Name).Str("on_event", "retries_exhausted_webhook").Err(err).Msg("retries exhausted webhook notify failed")
+				if m := j.metrics(); m != nil {
+					m.observeWebhookFailure(j.Name, wu.Name())
+				}
+				j.log.Warn().Str("job", j.Name).Str("on_event", event).Str("notifier", wu.Name()).Err(err).Msg("webhook notify failed")
 			}
-			j.log.Debug().Str("job", jr.Name).Str("webhook_call", "retries_exhausted_response").Str("webhook_url", wu.URL()).Msg(string(resp_body))
-		}(&wg, wu)
+			j.log.Debug().Str("job", run.Name).Str("on_event", event).Str("webhook_url", wu.URL()).Msg(string(resp_body))
+		}(wu)
 	}
+}
 
-	wg.Wait() // this allows to wait for go routines when running just the job exec
+// specEqual reports whether two jobs share the same specification. Env and
+// webhook secrets are compared separately as they are masked when
+// marshalling.
+func (j *JobSpec) specEqual(o *JobSpec) bool {
+	a, errA := j.marshalSpec()
+	b, errB := o.marshalSpec()
+	if errA != nil || errB != nil {
+		return false
+	}
+	return bytes.Equal(a, b) && reflect.DeepEqual(j.Env, o.Env) && reflect.DeepEqual(j.webhookSecrets(), o.webhookSecrets())
+}
+
+// marshalSpec returns the spec of the job as YAML. The spec of a scheduled
+// job is marshalled when the schedule is initialized, marshalling copies the
+// job and so can't happen while it runs.
+func (j *JobSpec) marshalSpec() ([]byte, error) {
+	if j.spec != nil {
+		return j.spec, nil
+	}
+	return yaml.Marshal(j)
+}
+
+// events returns all events of the job.
+func (j *JobSpec) events() []*OnEvent {
+	return []*OnEvent{&j.OnSuccess, &j.OnError, &j.OnRetriesExhausted, &j.OnTimeout, &j.OnCancel, &j.OnRecovery, &j.OnStart, &j.OnMissed, &j.OnDurationExceeded}
+}
+
+func (j *JobSpec) webhookSecrets() []any {
+	var secrets []any
+	for _, e := range j.events() {
+		secrets = append(secrets, e.secrets()...)
+	}
+	return secrets
+}
+
+// ValidateNotifications checks the alerting settings and the webhook targets
+// of all events of the job.
+func (j *JobSpec) ValidateNotifications() error {
+	if err := validateAlerting(j.NotifyOn, j.AlertAfterFailures, fmt.Sprintf("job '%s'", j.Name)); err != nil {
+		return err
+	}
+	for _, e := range j.events() {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("job '%s': %w", j.Name, err)
+		}
+	}
+	return nil
 }
 
 func (j *JobSpec) ToYAML(includeRuns bool) (string, error) {
 	if !includeRuns {
+		j.mutex.Lock()
 		j.Runs = []JobRun{}
+		j.mutex.Unlock()
 	}
 
 	yData, err := yaml.Marshal(j)
@@ -539,9 +924,14 @@ func RunJob(log zerolog.Logger, cfg Config, scheduleFn string, jobName string) (
 			// Use the setup function to create a JobRun instance
 			jr := job.setup("manual", nil)
 
+			var started sync.WaitGroup
+			job.startEvent(&started, jr)
+
 			// Execute the command with the initialized JobRun and the trigger string
 			jr = job.execCommand(context.Background(), jr, "manual")
 			job.finalize(&jr)
+			started.Wait()
+			s.deliveries.Wait()
 			return jr, nil
 		}
 	}
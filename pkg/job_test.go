@@ -1,7 +1,6 @@
 package cheek
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -243,7 +243,7 @@ func TestOnEventWebhook(t *testing.T) {
 		Command: []string{"echo"},
 		cfg:     NewConfig(),
 		OnSuccess: OnEvent{
-			NotifyWebhook: []string{testServer.URL},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL}},
 		},
 	}
 	jobRun := JobRun{}                  // Create a JobRun instance
@@ -336,10 +336,10 @@ func TestOnRetriesExhausted(t *testing.T) {
 		cfg:     NewConfig(),
 		log:     NewLogger("debug", nil, os.Stdout, os.Stdout),
 		OnError: OnEvent{
-			NotifyWebhook: []string{testServer.URL + "/error"},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/error"}},
 		},
 		OnRetriesExhausted: OnEvent{
-			NotifyWebhook: []string{testServer.URL + "/retries-exhausted"},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/retries-exhausted"}},
 		},
 	}
 
@@ -378,7 +378,7 @@ func TestOnRetriesExhaustedNoRetries(t *testing.T) {
 		cfg:     NewConfig(),
 		log:     NewLogger("debug", nil, os.Stdout, os.Stdout),
 		OnRetriesExhausted: OnEvent{
-			NotifyWebhook: []string{testServer.URL + "/retries-exhausted"},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/retries-exhausted"}},
 		},
 	}
 
@@ -414,7 +414,7 @@ func TestOnRetriesExhaustedSuccess(t *testing.T) {
 		cfg:     NewConfig(),
 		log:     NewLogger("debug", nil, os.Stdout, os.Stdout),
 		OnRetriesExhausted: OnEvent{
-			NotifyWebhook: []string{testServer.URL + "/retries-exhausted"},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/retries-exhausted"}},
 		},
 	}
 
@@ -459,10 +459,10 @@ func TestRetryContextInWebhooks(t *testing.T) {
 		cfg:     NewConfig(),
 		log:     NewLogger("debug", nil, os.Stdout, os.Stdout),
 		OnError: OnEvent{
-			NotifyWebhook: []string{testServer.URL},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL}},
 		},
 		OnRetriesExhausted: OnEvent{
-			NotifyWebhook: []string{testServer.URL},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL}},
 		},
 	}
 
@@ -539,7 +539,7 @@ func TestExecCommandStartError(t *testing.T) {
 	jobRun := JobRun{
 		LogEntryId:  1,
 		Status:      nil,
-		logBuf:      bytes.Buffer{},
+		logBuf:      new(tsBuffer),
 		Log:         "",
 		Name:        "TestJob",
 		TriggeredAt: time.Now(),
@@ -575,7 +575,7 @@ func TestExecCommandExitError(t *testing.T) {
 	jobRun := JobRun{
 		LogEntryId:  1,
 		Status:      nil,
-		logBuf:      bytes.Buffer{},
+		logBuf:      new(tsBuffer),
 		Log:         "",
 		Name:        "TestJob",
 		TriggeredAt: time.Now(),
@@ -658,7 +658,7 @@ func TestTriggeredByJobRunContext(t *testing.T) {
 		cfg:     NewConfig(),
 		log:     NewLogger("debug", nil, os.Stdout, os.Stdout),
 		OnSuccess: OnEvent{
-			NotifyWebhook: []string{testServer.URL},
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL}},
 		},
 	}
 
@@ -683,8 +683,8 @@ func TestTriggeredByJobRunContext(t *testing.T) {
 	// Execute the parent job
 	_ = parentJob.execCommandWithRetry(context.Background(), "manual", nil)
 
-	// Give time for the triggered job to complete
-	time.Sleep(100 * time.Millisecond)
+	// Wait for the notification of the triggered job
+	schedule.deliveries.Wait()
 
 	// Verify webhook was called with child job data
 	assert.NotNil(t, webhookPayload, "Webhook should have been called")
@@ -703,3 +703,119 @@ func TestTriggeredByJobRunContext(t *testing.T) {
 	assert.Equal(t, float64(0), parentContext["status"], "Parent job should have succeeded")
 	assert.Contains(t, parentContext["log"], "parent output", "Parent job log should contain expected output")
 }
+
+func TestJobTimeout(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+
+	j := &JobSpec{
+		Name:    "slow-job",
+		Command: []string{"sleep", "5"},
+		Timeout: 200 * time.Millisecond,
+		cfg:     cfg,
+		log:     NewLogger("debug", nil, os.Stdout),
+	}
+
+	start := time.Now()
+	jr := j.execCommand(context.Background(), JobRun{TriggeredAt: start}, "test")
+	jr.flushLogBuffer()
+
+	assert.Equal(t, StatusTimeout, *jr.Status)
+	assert.Contains(t, jr.Log, "Job timed out after 200ms")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestJobTimeoutKillEscalation(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+
+	// the ignored SIGTERM is inherited by sleep, so only SIGKILL stops this
+	j := &JobSpec{
+		Name:            "stubborn-job",
+		Command:         []string{"sh", "-c", "trap '' TERM; sleep 5"},
+		Timeout:         200 * time.Millisecond,
+		KillGracePeriod: 300 * time.Millisecond,
+		cfg:             cfg,
+		log:             NewLogger("debug", nil, os.Stdout),
+	}
+
+	start := time.Now()
+	jr := j.execCommand(context.Background(), JobRun{TriggeredAt: start}, "test")
+
+	assert.Equal(t, StatusTimeout, *jr.Status)
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestJobTimeoutCleanExit(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+
+	// the job exits cleanly once it is told to stop, it still timed out
+	j := &JobSpec{
+		Name:    "trapping-job",
+		Command: []string{"sh", "-c", "trap 'exit 0' TERM; sleep 5 & wait"},
+		Timeout: 200 * time.Millisecond,
+		cfg:     cfg,
+		log:     NewLogger("debug", nil, os.Stdout),
+	}
+
+	jr := j.execCommand(context.Background(), JobRun{TriggeredAt: time.Now()}, "test")
+	jr.flushLogBuffer()
+
+	assert.Equal(t, StatusTimeout, *jr.Status)
+	assert.Contains(t, jr.Log, "Job timed out after 200ms")
+}
+
+func TestOnTimeoutEvent(t *testing.T) {
+	var paths []string
+	var mu sync.Mutex
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+
+	j := &JobSpec{
+		Name:    "timeout-job",
+		Command: []string{"sleep", "5"},
+		Timeout: 100 * time.Millisecond,
+		cfg:     cfg,
+		log:     NewLogger("debug", nil, os.Stdout),
+		OnError: OnEvent{
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/error"}},
+		},
+		OnTimeout: OnEvent{
+			NotifyWebhook: []WebhookTarget{{URL: testServer.URL + "/timeout"}},
+		},
+	}
+
+	jr := j.execCommandWithRetry(context.Background(), "test", nil)
+	assert.Equal(t, StatusTimeout, *jr.Status)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"/error", "/timeout"}, paths)
+}
+
+func TestInvalidTimeout(t *testing.T) {
+	j := &JobSpec{
+		Name:    "test",
+		Command: []string{"echo", "bar"},
+		Timeout: -1 * time.Second,
+	}
+	assert.Error(t, j.ValidateTimeout())
+
+	j.Timeout = time.Second
+	j.KillGracePeriod = -1 * time.Second
+	assert.Error(t, j.ValidateTimeout())
+
+	j.KillGracePeriod = time.Second
+	assert.NoError(t, j.ValidateTimeout())
+}
@@ -0,0 +1,257 @@
+package cheek
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the run duration
+// histogram buckets.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// metricsRegistry collects the metrics of a schedule that can't be derived
+// from its current state when scraped.
+type metricsRegistry struct {
+	mutex           sync.Mutex
+	runs            map[[2]string]uint64 // keyed by job and status
+	durations       map[string]*histogram
+	lastSuccess     map[string]time.Time
+	retries         map[string]uint64
+	webhookFailures map[[2]string]uint64 // keyed by job and webhook type
+	dbWriteErrors   uint64
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range durationBuckets {
+		if v <= le {
+			h.buckets[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// statusLabel maps the status of a run to the value of its status label.
+func statusLabel(status int) string {
+	switch status {
+	case StatusOK:
+		return "ok"
+	case StatusTimeout:
+		return "timeout"
+	case StatusSkipped:
+		return "skipped"
+	case StatusReplaced:
+		return "replaced"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusMissed:
+		return "missed"
+	}
+	return "error"
+}
+
+// observeRun records a finished run. Runs that did not execute, like skipped
+// ones, are counted but don't add to the duration histogram.
+func (m *metricsRegistry) observeRun(jr *JobRun, executed bool, at time.Time) {
+	if jr.Status == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.runs == nil {
+		m.runs = make(map[[2]string]uint64)
+		m.durations = make(map[string]*histogram)
+		m.lastSuccess = make(map[string]time.Time)
+	}
+
+	m.runs[[2]string{jr.Name, statusLabel(*jr.Status)}]++
+	if !executed {
+		return
+	}
+	h, ok := m.durations[jr.Name]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		m.durations[jr.Name] = h
+	}
+	// the duration of a run is kept in milliseconds
+	h.observe(float64(jr.Duration) / 1000)
+	if *jr.Status == StatusOK {
+		m.lastSuccess[jr.Name] = at
+	}
+}
+
+func (m *metricsRegistry) observeRetry(job string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.retries == nil {
+		m.retries = make(map[string]uint64)
+	}
+	m.retries[job]++
+}
+
+func (m *metricsRegistry) observeWebhookFailure(job, webhookType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.webhookFailures == nil {
+		m.webhookFailures = make(map[[2]string]uint64)
+	}
+	m.webhookFailures[[2]string{job, webhookType}]++
+}
+
+func (m *metricsRegistry) observeDbWriteError() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dbWriteErrors++
+}
+
+// metrics returns the metrics registry of the job's schedule, or nil when the
+// job runs on its own.
+func (j *JobSpec) metrics() *metricsRegistry {
+	if j.globalSchedule == nil {
+		return nil
+	}
+	return &j.globalSchedule.metrics
+}
+
+// observeRun records a finished run in the metrics of the schedule.
+func (j *JobSpec) observeRun(jr *JobRun, executed bool) {
+	if m := j.metrics(); m != nil {
+		m.observeRun(jr, executed, j.now())
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw metricsWriter) header(name, typ, help string) {
+	_, _ = fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw metricsWriter) sample(name string, v float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	_, _ = fmt.Fprintf(mw.w, "%s %s\n", b.String(), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func sortedKeys[K [2]string | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
+
+// writeMetrics writes all metrics of the schedule to w.
+func (s *Schedule) writeMetrics(w io.Writer) {
+	mw := metricsWriter{w}
+	m := &s.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mw.header("cheek_job_runs_total", "counter", "Number of finished runs by job and status.")
+	for _, k := range sortedKeys(m.runs) {
+		mw.sample("cheek_job_runs_total", float64(m.runs[k]), "job", k[0], "status", k[1])
+	}
+
+	mw.header("cheek_job_run_duration_seconds", "histogram", "Duration of the runs of a job.")
+	for _, job := range sortedKeys(m.durations) {
+		h := m.durations[job]
+		for i, le := range durationBuckets {
+			mw.sample("cheek_job_run_duration_seconds_bucket", float64(h.buckets[i]), "job", job, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		mw.sample("cheek_job_run_duration_seconds_bucket", float64(h.count), "job", job, "le", "+Inf")
+		mw.sample("cheek_job_run_duration_seconds_sum", h.sum, "job", job)
+		mw.sample("cheek_job_run_duration_seconds_count", float64(h.count), "job", job)
+	}
+
+	mw.header("cheek_job_last_success_timestamp_seconds", "gauge", "Time the last successful run of a job finished.")
+	for _, job := range sortedKeys(m.lastSuccess) {
+		mw.sample("cheek_job_last_success_timestamp_seconds", float64(m.lastSuccess[job].Unix()), "job", job)
+	}
+
+	mw.header("cheek_job_retries_total", "counter", "Number of retries launched by job.")
+	for _, job := range sortedKeys(m.retries) {
+		mw.sample("cheek_job_retries_total", float64(m.retries[job]), "job", job)
+	}
+
+	mw.header("cheek_webhook_delivery_failures_total", "counter", "Number of webhook calls that failed by job and webhook type.")
+	for _, k := range sortedKeys(m.webhookFailures) {
+		mw.sample("cheek_webhook_delivery_failures_total", float64(m.webhookFailures[k]), "job", k[0], "type", k[1])
+	}
+
+	mw.header("cheek_db_write_errors_total", "counter", "Number of runs that could not be saved to the db.")
+	mw.sample("cheek_db_write_errors_total", float64(m.dbWriteErrors))
+
+	// the current state of the jobs is read when scraped
+	jobs := s.jobs()
+	names := sortedKeys(jobs)
+	mw.header("cheek_job_next_tick_timestamp_seconds", "gauge", "Time of the next scheduled run of a job.")
+	for _, name := range names {
+		nextTick := jobs[name].scheduledTick()
+		if nextTick.IsZero() {
+			continue
+		}
+		mw.sample("cheek_job_next_tick_timestamp_seconds", float64(nextTick.Unix()), "job", name)
+	}
+
+	running := make(map[string]int, len(jobs))
+	queued := make(map[string]int, len(jobs))
+	for name, j := range jobs {
+		running[name], queued[name] = j.overlapState()
+	}
+
+	mw.header("cheek_job_runs_in_flight", "gauge", "Number of runs of a job in progress.")
+	for _, name := range names {
+		mw.sample("cheek_job_runs_in_flight", float64(running[name]), "job", name)
+	}
+
+	mw.header("cheek_job_runs_queued", "gauge", "Number of runs of a job waiting for a previous run to finish.")
+	for _, name := range names {
+		mw.sample("cheek_job_runs_queued", float64(queued[name]), "job", name)
+	}
+}
+
+func getMetrics(s *Schedule) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	}
+}
@@ -0,0 +1,101 @@
+package cheek
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	// a webhook server that is gone
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"ok": {
+			Command: []string{"true"},
+			Cron:    "* * * * *",
+		},
+		"fail": {
+			Command:    []string{"false"},
+			Retries:    1,
+			RetryDelay: time.Millisecond,
+			OnError:    OnEvent{NotifyWebhook: []WebhookTarget{{URL: ts.URL, DeliveryOptions: DeliveryOptions{RetryDelay: time.Millisecond}}}},
+		},
+	})
+	assert.NoError(t, s.Jobs["ok"].setNextTick(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), false))
+
+	s.Jobs["ok"].run(context.Background(), "test", nil)
+	s.Jobs["ok"].run(context.Background(), "test", nil)
+	s.Jobs["fail"].run(context.Background(), "test", nil)
+	s.deliveries.Wait()
+
+	// a run that is skipped is counted but not timed
+	skipped := s.Jobs["ok"].setup("test", nil)
+	s.Jobs["ok"].skipRun(skipped, "test")
+
+	router := setupRouter(s)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/plain")
+	body := rr.Body.String()
+
+	assert.Contains(t, body, "# TYPE cheek_job_runs_total counter\n")
+	assert.Contains(t, body, `cheek_job_runs_total{job="ok",status="ok"} 2`+"\n")
+	assert.Contains(t, body, `cheek_job_runs_total{job="ok",status="skipped"} 1`+"\n")
+	assert.Contains(t, body, `cheek_job_runs_total{job="fail",status="error"} 2`+"\n")
+	assert.Contains(t, body, "# TYPE cheek_job_run_duration_seconds histogram\n")
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_bucket{job="ok",le="+Inf"} 2`+"\n")
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_count{job="fail"} 2`+"\n")
+	assert.Contains(t, body, `cheek_job_last_success_timestamp_seconds{job="ok"} `)
+	assert.NotContains(t, body, `cheek_job_last_success_timestamp_seconds{job="fail"}`)
+	assert.Contains(t, body, `cheek_job_retries_total{job="fail"} 1`+"\n")
+	assert.Contains(t, body, `cheek_webhook_delivery_failures_total{job="fail",type="generic"} 2`+"\n")
+	assert.Contains(t, body, "cheek_db_write_errors_total 0\n")
+	assert.Contains(t, body, `cheek_job_next_tick_timestamp_seconds{job="ok"} 1.76722566e+09`+"\n")
+	assert.NotContains(t, body, `cheek_job_next_tick_timestamp_seconds{job="fail"}`)
+	assert.Contains(t, body, `cheek_job_runs_in_flight{job="ok"} 0`+"\n")
+}
+
+func TestMetricsRunDuration(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"ok": {Command: []string{"true"}},
+	})
+	status := StatusOK
+	// durations of runs are kept in milliseconds
+	for _, ms := range []int{2500, 4000} {
+		s.metrics.observeRun(&JobRun{Name: "ok", Status: &status, Duration: time.Duration(ms)}, true, s.now())
+	}
+
+	var b strings.Builder
+	s.writeMetrics(&b)
+	body := b.String()
+
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_bucket{job="ok",le="1"} 0`+"\n")
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_bucket{job="ok",le="5"} 2`+"\n")
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_sum{job="ok"} 6.5`+"\n")
+	assert.Contains(t, body, `cheek_job_run_duration_seconds_count{job="ok"} 2`+"\n")
+}
+
+func TestMetricsDbWriteErrors(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"ok": {Command: []string{"true"}},
+	})
+	assert.NoError(t, s.cfg.DB.Close())
+
+	s.Jobs["ok"].run(context.Background(), "test", nil)
+
+	assert.Greater(t, s.metrics.dbWriteErrors, uint64(0))
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabel("a\"b\\c\nd"))
+}
@@ -0,0 +1,275 @@
+package cheek
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a versioned step in the evolution of the db schema.
+// Migrations are applied in order and have to be idempotent, as databases
+// created before versioning was introduced already contain (part of) the
+// schema without a record of it.
+type Migration struct {
+	Version     int
+	Description string
+	migrate     func(tx *sqlx.Tx) error
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create log table",
+		migrate: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				job TEXT,
+				triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				triggered_by TEXT,
+				duration INTEGER,
+				status INTEGER,
+				message TEXT,
+				UNIQUE(job, triggered_at, triggered_by)
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "remove duplicate log records",
+		migrate: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`
+				DELETE FROM log
+				WHERE id NOT IN (
+					SELECT MIN(id)
+					FROM log
+					GROUP BY job, triggered_at, triggered_by
+				)`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "create run_lineage table",
+		migrate: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS run_lineage (
+				run_id INTEGER,
+				parent_run_id INTEGER,
+				UNIQUE(run_id, parent_run_id)
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "add retry attempt columns to log table",
+		migrate: func(tx *sqlx.Tx) error {
+			for _, c := range []struct{ name, def string }{
+				{"attempt", "INTEGER NOT NULL DEFAULT 0"},
+				{"parent_run_id", "INTEGER NOT NULL DEFAULT 0"},
+				{"retries_exhausted", "BOOLEAN NOT NULL DEFAULT 0"},
+				{"trigger_run_id", "INTEGER NOT NULL DEFAULT 0"},
+			} {
+				if err := addColumnIfMissing(tx, "log", c.name, c.def); err != nil {
+					return fmt.Errorf("add %s column: %w", c.name, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "create webhook_delivery table",
+		migrate: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS webhook_delivery (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				run_id INTEGER NOT NULL,
+				job TEXT NOT NULL,
+				webhook TEXT NOT NULL,
+				host TEXT NOT NULL,
+				attempt INTEGER NOT NULL DEFAULT 0,
+				status_code INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				duration INTEGER NOT NULL DEFAULT 0,
+				sent_at DATETIME NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("CREATE INDEX IF NOT EXISTS webhook_delivery_run_id ON webhook_delivery (run_id)")
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "add duration_exceeded column to log table",
+		migrate: func(tx *sqlx.Tx) error {
+			return addColumnIfMissing(tx, "log", "duration_exceeded", "BOOLEAN NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		Version:     7,
+		Description: "create one_shot table",
+		migrate: func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS one_shot (
+				job TEXT NOT NULL,
+				at DATETIME NOT NULL,
+				fired_at DATETIME NOT NULL,
+				PRIMARY KEY (job, at)
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "add unique constraint to legacy log table",
+		migrate: func(tx *sqlx.Tx) error {
+			ok, err := hasUniqueIndex(tx, "log", "job", "triggered_at", "triggered_by")
+			if err != nil || ok {
+				return err
+			}
+
+			// SQLite can't add a constraint to an existing table, so the
+			// table is rebuilt. Duplicates were removed by migration 2.
+			const columns = "id, job, triggered_at, triggered_by, duration, status, message, attempt, parent_run_id, retries_exhausted, trigger_run_id, duration_exceeded"
+			for _, stmt := range []string{
+				`CREATE TABLE log_new (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					job TEXT,
+					triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					triggered_by TEXT,
+					duration INTEGER,
+					status INTEGER,
+					message TEXT,
+					attempt INTEGER NOT NULL DEFAULT 0,
+					parent_run_id INTEGER NOT NULL DEFAULT 0,
+					retries_exhausted BOOLEAN NOT NULL DEFAULT 0,
+					trigger_run_id INTEGER NOT NULL DEFAULT 0,
+					duration_exceeded BOOLEAN NOT NULL DEFAULT 0,
+					UNIQUE(job, triggered_at, triggered_by)
+				)`,
+				"INSERT OR IGNORE INTO log_new (" + columns + ") SELECT " + columns + " FROM log ORDER BY id",
+				"DROP TABLE log",
+				"ALTER TABLE log_new RENAME TO log",
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// schemaVersion returns the version of the last applied migration.
+func schemaVersion(db *sqlx.DB) (int, error) {
+	var n int
+	if err := db.Get(&n, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'"); err != nil {
+		return 0, fmt.Errorf("get schema version: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	var version int
+	if err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version"); err != nil {
+		return 0, fmt.Errorf("get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations that still need to be applied to
+// the db, in order.
+func PendingMigrations(db *sqlx.DB) ([]Migration, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateDB opens the db at dbPath and applies all pending migrations. With
+// dryRun set the pending migrations are only returned, not applied.
+func MigrateDB(dbPath string, dryRun bool) ([]Migration, error) {
+	if dryRun {
+		// opening the db would create it
+		if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+			return append([]Migration(nil), migrations...), nil
+		}
+	}
+
+	db, err := sqlx.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	pending, err := PendingMigrations(db)
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	if err := InitDB(db); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// apply runs the migration and records it in a single transaction.
+func (m Migration) apply(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := m.migrate(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addColumnIfMissing(tx *sqlx.Tx, table, column, def string) error {
+	var n int
+	if err := tx.Get(&n, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err
+}
+
+// hasUniqueIndex reports whether table has a unique index, or constraint, on
+// exactly the given columns.
+func hasUniqueIndex(tx *sqlx.Tx, table string, columns ...string) (bool, error) {
+	var indexes []string
+	if err := tx.Select(&indexes, `SELECT name FROM pragma_index_list(?) WHERE "unique" = 1`, table); err != nil {
+		return false, err
+	}
+	for _, index := range indexes {
+		var indexed []string
+		if err := tx.Select(&indexed, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", index); err != nil {
+			return false, err
+		}
+		if strings.Join(indexed, ",") == strings.Join(columns, ",") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
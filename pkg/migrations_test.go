@@ -0,0 +1,156 @@
+package cheek
+
+import (
+	"path"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtures of db layouts created by cheek versions from before schema
+// versioning was introduced
+var legacyLayouts = map[string][]string{
+	"empty": nil,
+	"log table without unique constraint": {
+		`CREATE TABLE log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job TEXT,
+			triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			triggered_by TEXT,
+			duration INTEGER,
+			status INTEGER,
+			message TEXT
+		)`,
+		`INSERT INTO log (job, triggered_at, triggered_by, duration, status, message) VALUES
+			('job1', '2023-10-01 10:00:00', 'cron', 120, 0, 'first'),
+			('job1', '2023-10-01 10:00:00', 'cron', 150, 0, 'duplicate'),
+			('job1', '2023-10-01 11:00:00', 'ui', 90, 1, 'second')`,
+	},
+	"log table": {
+		`CREATE TABLE log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job TEXT,
+			triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			triggered_by TEXT,
+			duration INTEGER,
+			status INTEGER,
+			message TEXT,
+			UNIQUE(job, triggered_at, triggered_by)
+		)`,
+		`INSERT INTO log (job, triggered_at, triggered_by, duration, status, message) VALUES
+			('job1', '2023-10-01 10:00:00', 'cron', 120, 0, 'first'),
+			('job1', '2023-10-01 11:00:00', 'ui', 90, 1, 'second')`,
+	},
+	"log and run_lineage tables": {
+		`CREATE TABLE log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job TEXT,
+			triggered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			triggered_by TEXT,
+			duration INTEGER,
+			status INTEGER,
+			message TEXT,
+			UNIQUE(job, triggered_at, triggered_by)
+		)`,
+		`CREATE TABLE run_lineage (
+			run_id INTEGER,
+			parent_run_id INTEGER,
+			UNIQUE(run_id, parent_run_id)
+		)`,
+		`INSERT INTO log (job, triggered_at, triggered_by, duration, status, message) VALUES
+			('job1', '2023-10-01 10:00:00', 'cron', 120, 0, 'first'),
+			('job2', '2023-10-01 10:01:00', 'job[job1]', 90, 1, 'second')`,
+		`INSERT INTO run_lineage (run_id, parent_run_id) VALUES (2, 1)`,
+	},
+}
+
+func openLegacyDB(t *testing.T, stmts []string) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite", path.Join(t.TempDir(), "legacy.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migrations should be numbered in order")
+		assert.NotEmpty(t, m.Description)
+	}
+}
+
+func TestMigrateLegacyLayouts(t *testing.T) {
+	latest := migrations[len(migrations)-1].Version
+
+	for name, stmts := range legacyLayouts {
+		t.Run(name, func(t *testing.T) {
+			db := openLegacyDB(t, stmts)
+
+			var before int
+			if len(stmts) > 0 {
+				assert.NoError(t, db.Get(&before, "SELECT COUNT(DISTINCT job || triggered_at || triggered_by) FROM log"))
+			}
+
+			pending, err := PendingMigrations(db)
+			assert.NoError(t, err)
+			assert.Len(t, pending, len(migrations))
+
+			assert.NoError(t, InitDB(db))
+
+			version, err := schemaVersion(db)
+			assert.NoError(t, err)
+			assert.Equal(t, latest, version)
+
+			// existing runs are kept, duplicates removed
+			var after int
+			assert.NoError(t, db.Get(&after, "SELECT COUNT(*) FROM log"))
+			assert.Equal(t, before, after)
+
+			// the current schema can be used
+			_, err = db.Exec("INSERT INTO log (job, triggered_at, triggered_by, attempt, parent_run_id, retries_exhausted, trigger_run_id) VALUES ('job1', '2024-01-01 00:00:00', 'cron', 1, 1, true, 0)")
+			assert.NoError(t, err)
+			_, err = db.Exec("INSERT INTO run_lineage (run_id, parent_run_id) VALUES (3, 1)")
+			assert.NoError(t, err)
+
+			// runs are upserted on their unique constraint
+			_, err = db.Exec("INSERT INTO log (job, triggered_at, triggered_by, status) VALUES ('job1', '2024-01-01 00:00:00', 'cron', 0) ON CONFLICT(job, triggered_at, triggered_by) DO UPDATE SET status = excluded.status")
+			assert.NoError(t, err)
+
+			// running the migrations again is a no-op
+			pending, err = PendingMigrations(db)
+			assert.NoError(t, err)
+			assert.Empty(t, pending)
+			assert.NoError(t, InitDB(db))
+		})
+	}
+}
+
+func TestMigrateDBDryRun(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "cheek.sqlite3")
+
+	pending, err := MigrateDB(dbPath, true)
+	assert.NoError(t, err)
+	assert.Len(t, pending, len(migrations))
+	assert.NoFileExists(t, dbPath, "a dry run should not create the db")
+
+	pending, err = MigrateDB(dbPath, true)
+	assert.NoError(t, err)
+	assert.Len(t, pending, len(migrations), "a dry run should not apply migrations")
+
+	pending, err = MigrateDB(dbPath, false)
+	assert.NoError(t, err)
+	assert.Len(t, pending, len(migrations))
+
+	pending, err = MigrateDB(dbPath, true)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
@@ -0,0 +1,134 @@
+package cheek
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// missedState tracks when a job last executed, to tell whether it went
+// longer than its expect_run_within without a run.
+type missedState struct {
+	mutex   sync.Mutex
+	lastRun time.Time
+	overdue bool
+}
+
+// expectRunWithin returns the time within which the job should run, zero if
+// it isn't checked. max_staleness is an alias of expect_run_within.
+func (j *JobSpec) expectRunWithin() time.Duration {
+	if j.ExpectRunWithin > 0 {
+		return j.ExpectRunWithin
+	}
+	return j.MaxStaleness
+}
+
+func (j *JobSpec) ValidateExpectRunWithin() error {
+	if j.ExpectRunWithin < 0 || j.MaxStaleness < 0 {
+		return fmt.Errorf("expect_run_within for job '%s' cannot be negative", j.Name)
+	}
+	if j.ExpectRunWithin > 0 && j.MaxStaleness > 0 {
+		return fmt.Errorf("job '%s' cannot set both expect_run_within and its alias max_staleness", j.Name)
+	}
+	return nil
+}
+
+// markRan records that the job executed at t, which also rearms the on_missed
+// event.
+func (j *JobSpec) markRan(t time.Time) {
+	j.missed.mutex.Lock()
+	defer j.missed.mutex.Unlock()
+	if t.After(j.missed.lastRun) {
+		j.missed.lastRun = t
+	}
+	j.missed.overdue = false
+}
+
+// isMissed reports whether the job is overdue.
+func (j *JobSpec) isMissed() bool {
+	j.missed.mutex.Lock()
+	defer j.missed.mutex.Unlock()
+	return j.missed.overdue
+}
+
+// lastExecutedRun returns the time of the last run that executed, runs that
+// were skipped or replaced before they started don't count.
+func (j *JobSpec) lastExecutedRun() (time.Time, error) {
+	var t time.Time
+	if j.cfg.DB == nil {
+		return t, errors.New("no db connection")
+	}
+	err := j.cfg.DB.Get(&t, "SELECT triggered_at FROM log WHERE job = ? AND (status IS NULL OR status NOT IN (?, ?)) ORDER BY triggered_at DESC LIMIT 1",
+		j.Name, StatusSkipped, StatusReplaced)
+	return t, err
+}
+
+// checkMissed reports whether the job went overdue at now. It does so once,
+// until the job runs again.
+func (j *JobSpec) checkMissed(now time.Time) bool {
+	within := j.expectRunWithin()
+	if within == 0 {
+		return false
+	}
+
+	j.missed.mutex.Lock()
+	defer j.missed.mutex.Unlock()
+
+	if j.missed.lastRun.IsZero() {
+		// first check, start from the last run in the db
+		last, err := j.lastExecutedRun()
+		switch {
+		case err == nil:
+			j.missed.lastRun = last
+		case j.cfg.DB == nil || errors.Is(err, sql.ErrNoRows):
+			// never ran before, give it a window from now
+			j.missed.lastRun = now
+		default:
+			j.log.Warn().Str("job", j.Name).Err(err).Msg("Cannot determine last run, not checking for missed runs")
+			return false
+		}
+	}
+
+	if j.missed.overdue || now.Sub(j.missed.lastRun) <= within {
+		return false
+	}
+	j.missed.overdue = true
+	return true
+}
+
+// checkMissed fires the on_missed events of the jobs that went overdue.
+func (s *Schedule) checkMissed(wg *sync.WaitGroup, now time.Time) {
+	for _, j := range s.Jobs {
+		if !j.checkMissed(now) {
+			continue
+		}
+		wg.Add(1)
+		go func(j *JobSpec) {
+			defer wg.Done()
+			j.OnMissedEvent(now)
+		}(j)
+	}
+}
+
+// OnMissedEvent fires the on_missed events of a job that didn't run within
+// its expect_run_within. The run passed to them is not stored.
+func (j *JobSpec) OnMissedEvent(now time.Time) {
+	j.missed.mutex.Lock()
+	last := j.missed.lastRun
+	j.missed.mutex.Unlock()
+
+	status := StatusMissed
+	jr := JobRun{
+		Name:        j.Name,
+		Status:      &status,
+		TriggeredAt: now,
+		TriggeredBy: "missed",
+		Log:         fmt.Sprintf("Job did not run within %s, last run at %s\n", j.expectRunWithin(), last.Format(time.RFC3339)),
+		jobRef:      j,
+	}
+	j.log.Warn().Str("job", j.Name).Time("last_run", last).Msgf("Job did not run within %s", j.expectRunWithin())
+
+	j.fireEvents(&jr, "missed", j.OnMissed, func(s *Schedule) *OnEvent { return &s.OnMissed })
+}
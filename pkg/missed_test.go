@@ -0,0 +1,125 @@
+package cheek
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExpectRunWithin(t *testing.T) {
+	j := JobSpec{Name: "job", ExpectRunWithin: -time.Minute}
+	assert.ErrorContains(t, j.ValidateExpectRunWithin(), "cannot be negative")
+
+	j = JobSpec{Name: "job", ExpectRunWithin: time.Minute, MaxStaleness: time.Hour}
+	assert.ErrorContains(t, j.ValidateExpectRunWithin(), "cannot set both")
+
+	j = JobSpec{Name: "job", MaxStaleness: time.Hour}
+	assert.NoError(t, j.ValidateExpectRunWithin())
+	assert.Equal(t, time.Hour, j.expectRunWithin())
+}
+
+func TestCheckMissed(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"stale":     {Command: []string{"true"}, ExpectRunWithin: time.Minute},
+		"new":       {Command: []string{"true"}, ExpectRunWithin: time.Minute},
+		"unwatched": {Command: []string{"true"}},
+	})
+	now := s.now()
+	insert := func(at time.Time, status int) {
+		_, err := s.cfg.DB.Exec("INSERT INTO log (job, triggered_at, triggered_by, status) VALUES ('stale', ?, 'cron', ?)", at, status)
+		assert.NoError(t, err)
+	}
+	insert(now.Add(-2*time.Minute), StatusOK)
+	// a skipped run didn't execute, so it doesn't count
+	insert(now.Add(-30*time.Second), StatusSkipped)
+
+	stale := s.Jobs["stale"]
+	assert.True(t, stale.checkMissed(now))
+	assert.True(t, stale.isMissed())
+	assert.False(t, stale.checkMissed(now.Add(time.Second)), "on_missed fires once")
+
+	// a run rearms the check
+	stale.markRan(now)
+	assert.False(t, stale.isMissed())
+	assert.False(t, stale.checkMissed(now.Add(time.Minute)))
+	assert.True(t, stale.checkMissed(now.Add(2*time.Minute)))
+
+	// a job that never ran gets a window from the first check
+	assert.False(t, s.Jobs["new"].checkMissed(now))
+	assert.True(t, s.Jobs["new"].checkMissed(now.Add(2*time.Minute)))
+
+	assert.False(t, s.Jobs["unwatched"].checkMissed(now.Add(24*time.Hour)))
+}
+
+func TestOnMissed(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.TrimSpace(string(body)))
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"nightly": {
+			Command:         []string{"true"},
+			ExpectRunWithin: time.Hour,
+			OnMissed:        OnEvent{TriggerJob: []string{"page"}},
+		},
+		"page": {Command: []string{"echo", "paging"}},
+	})
+	s.OnMissed = OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL, Template: "{{ .Name }} {{ .StatusText }} {{ .Status }}"}}}
+	assert.NoError(t, s.ValidateNotifications())
+
+	now := s.now()
+	assert.False(t, s.Jobs["nightly"].checkMissed(now))
+	var wg sync.WaitGroup
+	s.checkMissed(&wg, now.Add(2*time.Hour))
+	wg.Wait()
+	s.deliveries.Wait()
+
+	mu.Lock()
+	assert.Equal(t, []string{"nightly missed -6"}, calls)
+	mu.Unlock()
+
+	var triggeredBy []string
+	assert.NoError(t, s.cfg.DB.Select(&triggeredBy, "SELECT triggered_by FROM log WHERE job = 'page'"))
+	assert.Equal(t, []string{"missed[nightly]"}, triggeredBy)
+
+	// the missed run itself is not stored
+	var n int
+	assert.NoError(t, s.cfg.DB.Get(&n, "SELECT COUNT(*) FROM log WHERE job = 'nightly'"))
+	assert.Equal(t, 0, n)
+}
+
+func TestScheduleStatusMissed(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"stale": {Command: []string{"true"}, ExpectRunWithin: time.Minute},
+		"fine":  {Command: []string{"true"}},
+	})
+	s.Jobs["fine"].run(context.Background(), "test", nil)
+	now := s.now()
+	s.Jobs["stale"].checkMissed(now)
+	s.Jobs["stale"].checkMissed(now.Add(2 * time.Minute))
+
+	req := httptest.NewRequest("GET", "/api/schedule/status", nil)
+	resp := httptest.NewRecorder()
+	setupRouter(s).ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var ssr ScheduleStatusResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&ssr))
+	assert.True(t, ssr.HasMissedRuns)
+	assert.Equal(t, []string{"stale"}, ssr.Missed)
+	assert.Equal(t, map[string]int{"fine": StatusOK}, ssr.Status)
+}
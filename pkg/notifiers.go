@@ -0,0 +1,301 @@
+package cheek
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// notifier registers a kind of notification: the field of OnEvent that holds
+// its targets and how to call, validate and mask them. Adding a kind of
+// notification takes a field on OnEvent and an entry here.
+type notifier struct {
+	targets  func(e *OnEvent) any // the field holding the targets
+	webhooks func(e *OnEvent) []webhook
+	validate func(e *OnEvent) error
+	secrets  func(e *OnEvent) []any
+}
+
+// notifierTarget is where a kind of notification is sent to.
+type notifierTarget[T any] interface {
+	*T
+	validate() error
+	secrets() []any
+}
+
+// register makes the notifier of the targets in a field of OnEvent, new
+// turns a target into the webhook that calls it.
+func register[T any, P notifierTarget[T]](targets func(e *OnEvent) *[]T, new func(t T) webhook) notifier {
+	return notifier{
+		targets: func(e *OnEvent) any { return targets(e) },
+		webhooks: func(e *OnEvent) []webhook {
+			var webhooks []webhook
+			for _, t := range *targets(e) {
+				webhooks = append(webhooks, new(t))
+			}
+			return webhooks
+		},
+		validate: func(e *OnEvent) error {
+			ts := *targets(e)
+			for i := range ts {
+				if err := P(&ts[i]).validate(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		secrets: func(e *OnEvent) []any {
+			var secrets []any
+			ts := *targets(e)
+			for i := range ts {
+				secrets = append(secrets, P(&ts[i]).secrets()...)
+			}
+			return secrets
+		},
+	}
+}
+
+var notifiers = []notifier{
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyWebhook }, func(t WebhookTarget) webhook { return defaultWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifySlackWebhook }, func(t WebhookTarget) webhook { return slackWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyDiscordWebhook }, func(t WebhookTarget) webhook { return discordWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyTeamsWebhook }, func(t WebhookTarget) webhook { return teamsWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyMattermostWebhook }, func(t WebhookTarget) webhook { return mattermostWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyGoogleChatWebhook }, func(t WebhookTarget) webhook { return googleChatWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyNtfy }, func(t WebhookTarget) webhook { return ntfyWebhook{t} }),
+	register(func(e *OnEvent) *[]WebhookTarget { return &e.NotifyGotify }, func(t WebhookTarget) webhook { return gotifyWebhook{t} }),
+	register(func(e *OnEvent) *[]EmailTarget { return &e.NotifyEmail }, func(t EmailTarget) webhook { return emailNotifier{t} }),
+}
+
+// notificationTitle is the title of notifications on platforms that have one.
+func notificationTitle(jr *JobRun) string {
+	return fmt.Sprintf("%s %s", jr.Name, newWebhookData(jr).StatusText)
+}
+
+// encodeJSON encodes a payload the way the other webhooks do.
+func encodeJSON(v any) ([]byte, error) {
+	payload := bytes.Buffer{}
+	if err := json.NewEncoder(&payload).Encode(v); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// Microsoft Teams Webhook
+
+type teamsWebhook struct {
+	target WebhookTarget
+}
+
+func (tw teamsWebhook) Call(jr *JobRun) ([]byte, error) {
+	type textBlock struct {
+		Type   string `json:"type"`
+		Text   string `json:"text"`
+		Wrap   bool   `json:"wrap"`
+		Weight string `json:"weight,omitempty"`
+		Size   string `json:"size,omitempty"`
+	}
+	type action struct {
+		Type  string `json:"type"`
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+	type card struct {
+		Schema  string      `json:"$schema"`
+		Type    string      `json:"type"`
+		Version string      `json:"version"`
+		Body    []textBlock `json:"body"`
+		Actions []action    `json:"actions,omitempty"`
+	}
+	type attachment struct {
+		ContentType string `json:"contentType"`
+		Content     card   `json:"content"`
+	}
+	type teamsPayload struct {
+		Type        string       `json:"type"`
+		Attachments []attachment `json:"attachments"`
+	}
+
+	msg, err := tw.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+	c := card{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body: []textBlock{
+			{Type: "TextBlock", Text: notificationTitle(jr), Wrap: true, Weight: "Bolder", Size: "Medium"},
+			{Type: "TextBlock", Text: truncateBytes(msg, 20000), Wrap: true}, // teams accepts messages of max. 28KB
+		},
+	}
+	if url := newWebhookData(jr).URL; url != "" {
+		c.Actions = []action{{Type: "Action.OpenUrl", Title: "View run", URL: url}}
+	}
+	payload, err := encodeJSON(teamsPayload{
+		Type:        "message",
+		Attachments: []attachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: c}},
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return tw.target.send(jr, tw.Name(), payload)
+}
+
+func (tw teamsWebhook) URL() string {
+	return tw.target.URL
+}
+
+func (tw teamsWebhook) Name() string {
+	return "teams"
+}
+
+// Mattermost Webhook
+
+type mattermostWebhook struct {
+	target WebhookTarget
+}
+
+func (mw mattermostWebhook) Call(jr *JobRun) ([]byte, error) {
+	type mattermostPayload struct {
+		Text string `json:"text"`
+	}
+	msg, err := mw.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+	payload, err := encodeJSON(mattermostPayload{
+		Text: truncateBytes(msg, 16383), // mattermost accepts a max. of 16383 chars
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return mw.target.send(jr, mw.Name(), payload)
+}
+
+func (mw mattermostWebhook) URL() string {
+	return mw.target.URL
+}
+
+func (mw mattermostWebhook) Name() string {
+	return "mattermost"
+}
+
+// Google Chat Webhook
+
+type googleChatWebhook struct {
+	target WebhookTarget
+}
+
+func (gw googleChatWebhook) Call(jr *JobRun) ([]byte, error) {
+	type googleChatPayload struct {
+		Text string `json:"text"`
+	}
+	msg, err := gw.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+	payload, err := encodeJSON(googleChatPayload{
+		Text: truncateBytes(msg, 4096), // google chat accepts a max. of 4096 chars
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return gw.target.send(jr, gw.Name(), payload)
+}
+
+func (gw googleChatWebhook) URL() string {
+	return gw.target.URL
+}
+
+func (gw googleChatWebhook) Name() string {
+	return "google_chat"
+}
+
+// ntfy
+
+// ntfyWebhook publishes to the topic in its URL, e.g. https://ntfy.sh/mytopic.
+type ntfyWebhook struct {
+	target WebhookTarget
+}
+
+func (nw ntfyWebhook) Call(jr *JobRun) ([]byte, error) {
+	msg, err := nw.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// ntfy takes the message as the body and the rest as headers
+	header := http.Header{
+		"Content-Type": {"text/plain; charset=utf-8"},
+		"Title":        {notificationTitle(jr)},
+		"Tags":         {"warning"},
+	}
+	if jr.Status == nil && jr.DurationExceeded {
+		header.Set("Tags", "hourglass")
+	} else if jr.Status == nil {
+		header.Set("Tags", "arrow_forward")
+	} else if *jr.Status == StatusOK {
+		header.Set("Tags", "white_check_mark")
+	}
+	if url := newWebhookData(jr).URL; url != "" {
+		header.Set("Click", url)
+	}
+
+	body := []byte(truncateBytes(msg, 4096)) // ntfy turns larger messages into attachments
+	return nw.target.sendWithHeader(jr, nw.Name(), header, body)
+}
+
+func (nw ntfyWebhook) URL() string {
+	return nw.target.URL
+}
+
+func (nw ntfyWebhook) Name() string {
+	return "ntfy"
+}
+
+// Gotify
+
+// gotifyWebhook posts to the message endpoint of a gotify server, with the
+// token of an application in the URL (?token=...) or the X-Gotify-Key header.
+type gotifyWebhook struct {
+	target WebhookTarget
+}
+
+func (gw gotifyWebhook) Call(jr *JobRun) ([]byte, error) {
+	type gotifyPayload struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	msg, err := gw.target.message(jr)
+	if err != nil {
+		return []byte{}, err
+	}
+	d := gotifyPayload{
+		Title:    notificationTitle(jr),
+		Message:  truncateBytes(msg, 65535), // gotify on mysql stores a max. of 65535 bytes
+		Priority: 8,
+	}
+	if jr.Status == nil || *jr.Status == StatusOK {
+		d.Priority = 4
+	}
+	payload, err := encodeJSON(d)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return gw.target.send(jr, gw.Name(), payload)
+}
+
+func (gw gotifyWebhook) URL() string {
+	return gw.target.URL
+}
+
+func (gw gotifyWebhook) Name() string {
+	return "gotify"
+}
@@ -0,0 +1,130 @@
+package cheek
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifiersRegistered(t *testing.T) {
+	// every list of targets of an event has a notifier
+	var e OnEvent
+	registered := map[uintptr]bool{}
+	for _, n := range notifiers {
+		registered[reflect.ValueOf(n.targets(&e)).Pointer()] = true
+	}
+
+	v := reflect.ValueOf(&e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if ft := v.Field(i).Type(); ft != reflect.TypeOf([]WebhookTarget{}) && ft != reflect.TypeOf([]EmailTarget{}) {
+			continue
+		}
+		assert.True(t, registered[v.Field(i).Addr().Pointer()], "%s has no notifier", v.Type().Field(i).Name)
+	}
+
+	e.NotifyTeamsWebhook = []WebhookTarget{{URL: "https://example.com/teams"}}
+	e.NotifyGotify = []WebhookTarget{{URL: "https://example.com/gotify"}}
+	var names []string
+	for _, wh := range e.webhooks() {
+		names = append(names, wh.Name())
+	}
+	assert.Equal(t, []string{"teams", "gotify"}, names)
+}
+
+func TestChatNotifiers(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header, body}
+	}))
+	defer testServer.Close()
+
+	status := 1
+	jr := JobRun{
+		LogEntryId: 3,
+		Status:     &status,
+		Name:       "backup",
+		Log:        strings.Repeat("x", 70000),
+		jobRef:     &JobSpec{globalSchedule: &Schedule{PublicURL: "https://cheek.example.com"}},
+	}
+	target := WebhookTarget{URL: testServer.URL}
+
+	// teams gets an adaptive card
+	_, err := teamsWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	req := <-requests
+	var teams struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Text string `json:"text"`
+				} `json:"body"`
+				Actions []struct {
+					URL string `json:"url"`
+				} `json:"actions"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	assert.NoError(t, json.Unmarshal(req.body, &teams))
+	assert.Equal(t, "message", teams.Type)
+	if assert.Len(t, teams.Attachments, 1) {
+		card := teams.Attachments[0]
+		assert.Equal(t, "application/vnd.microsoft.card.adaptive", card.ContentType)
+		assert.Equal(t, "AdaptiveCard", card.Content.Type)
+		assert.Equal(t, "backup error", card.Content.Body[0].Text)
+		assert.Len(t, card.Content.Body[1].Text, 20000)
+		assert.Equal(t, "https://cheek.example.com/jobs/backup/3", card.Content.Actions[0].URL)
+	}
+
+	// mattermost and google chat get text, each within their limit
+	for _, tt := range []struct {
+		wh    webhook
+		limit int
+	}{
+		{mattermostWebhook{target}, 16383},
+		{googleChatWebhook{target}, 4096},
+	} {
+		_, err = tt.wh.Call(&jr)
+		assert.NoError(t, err)
+		req = <-requests
+		var payload struct {
+			Text string `json:"text"`
+		}
+		assert.NoError(t, json.Unmarshal(req.body, &payload))
+		assert.Len(t, payload.Text, tt.limit, tt.wh.Name())
+		assert.True(t, strings.HasPrefix(payload.Text, "backup (exitcode 1):\n"), tt.wh.Name())
+	}
+
+	// ntfy gets plain text with the rest in headers
+	_, err = ntfyWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	req = <-requests
+	assert.Len(t, req.body, 4096)
+	assert.Equal(t, "text/plain; charset=utf-8", req.header.Get("Content-Type"))
+	assert.Equal(t, "backup error", req.header.Get("Title"))
+	assert.Equal(t, "warning", req.header.Get("Tags"))
+	assert.Equal(t, "https://cheek.example.com/jobs/backup/3", req.header.Get("Click"))
+
+	// gotify gets a title and a priority
+	status = StatusOK
+	jr.Log = "done"
+	gotify := WebhookTarget{URL: testServer.URL, Headers: map[string]secret{"X-Gotify-Key": "app-token"}}
+	_, err = gotifyWebhook{gotify}.Call(&jr)
+	assert.NoError(t, err)
+	req = <-requests
+	assert.Equal(t, "app-token", req.header.Get("X-Gotify-Key"))
+	assert.JSONEq(t, `{"title":"backup ok","message":"backup (exitcode 0):\ndone","priority":4}`, string(req.body))
+}
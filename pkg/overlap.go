@@ -0,0 +1,248 @@
+package cheek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Overlap policies, deciding what happens when a job is due while a previous
+// run of it is still in progress.
+const (
+	OverlapAllow   = "allow"
+	OverlapSkip    = "skip"
+	OverlapQueue   = "queue"
+	OverlapReplace = "replace"
+)
+
+// defaultOverlapQueueDepth is the number of runs that can wait for a running
+// instance when no overlap_queue_depth is configured.
+const defaultOverlapQueueDepth = 1
+
+// errRunReplaced is the cancellation cause of a run that made way for a newer
+// run under the replace overlap policy.
+var errRunReplaced = errors.New("replaced by a newer run")
+
+// overlapGuard tracks the runs of a job to enforce its overlap policy.
+type overlapGuard struct {
+	mutex   sync.Mutex
+	slot    chan struct{}
+	running int
+	queued  int
+	cancel  context.CancelCauseFunc // cancels the run holding the slot
+}
+
+// guard returns the overlap guard of the job, which is handed over to the new
+// spec of the job when it changes on reload.
+func (j *JobSpec) guard() *overlapGuard {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.overlap == nil {
+		j.overlap = new(overlapGuard)
+	}
+	return j.overlap
+}
+
+func (j *JobSpec) ValidateOverlapPolicy() error {
+	switch j.OverlapPolicy {
+	case "", OverlapAllow, OverlapSkip, OverlapQueue, OverlapReplace:
+	default:
+		return fmt.Errorf("overlap_policy '%s' for job '%s' not valid, should be one of %s|%s|%s|%s", j.OverlapPolicy, j.Name, OverlapAllow, OverlapSkip, OverlapQueue, OverlapReplace)
+	}
+	if j.DisableConcurrentExecution && j.OverlapPolicy == OverlapAllow {
+		return fmt.Errorf("job '%s' cannot both disable concurrent execution and allow overlapping runs", j.Name)
+	}
+	if j.OverlapQueueDepth < 0 {
+		return fmt.Errorf("overlap_queue_depth for job '%s' cannot be negative", j.Name)
+	}
+	return nil
+}
+
+// overlapPolicy returns the policy in effect, disable_concurrent_execution
+// being a shorthand for queueing runs.
+func (j *JobSpec) overlapPolicy() string {
+	switch {
+	case j.OverlapPolicy != "":
+		return j.OverlapPolicy
+	case j.DisableConcurrentExecution:
+		return OverlapQueue
+	default:
+		return OverlapAllow
+	}
+}
+
+// overlapQueueDepth returns the number of runs that can wait for a running
+// instance, 0 meaning there is no limit. Runs queued by
+// disable_concurrent_execution wait without limit, as they always have.
+func (j *JobSpec) overlapQueueDepth() int {
+	switch {
+	case j.OverlapQueueDepth > 0:
+		return j.OverlapQueueDepth
+	case j.OverlapPolicy == "" && j.DisableConcurrentExecution:
+		return 0
+	}
+	return defaultOverlapQueueDepth
+}
+
+// run executes the job with retries, after applying its overlap policy.
+func (j *JobSpec) run(ctx context.Context, trigger string, parentJobRun *JobRun, dependencyRuns ...*JobRun) JobRun {
+	jr := j.setup(trigger, parentJobRun, dependencyRuns...)
+	return j.execRun(ctx, jr, trigger)
+}
+
+// execRun applies the overlap policy to a run that has been set up and
+// executes it with retries when it is allowed to start.
+func (j *JobSpec) execRun(ctx context.Context, jr JobRun, trigger string) JobRun {
+	ctx, untrack := j.trackRun(ctx, &jr)
+	defer untrack()
+
+	// the run returns once the actions of its on_start events are done
+	var started sync.WaitGroup
+	defer started.Wait()
+
+	runCtx, release, waited, ok := j.startRun(ctx)
+	if !ok {
+		if ctx.Err() != nil {
+			return j.cancelRun(ctx, jr)
+		}
+		return j.skipRun(jr, trigger)
+	}
+	defer release()
+
+	if waited > 0 {
+		_, _ = fmt.Fprintf(jr.logs(), "Run was queued for %v behind a previous run (overlap_policy: %s)\n", waited.Round(time.Millisecond), OverlapQueue)
+	}
+	j.startEvent(&started, jr)
+	return j.execWithRetry(runCtx, jr, trigger)
+}
+
+// startRun waits for, or claims, the right to start a new run according to
+// the overlap policy. It returns the context to run with, a func to call once
+// the run is done and how long the run was queued, if at all. False is
+// returned when the run should not happen.
+func (j *JobSpec) startRun(ctx context.Context) (context.Context, func(), time.Duration, bool) {
+	g := j.guard()
+	g.mutex.Lock()
+
+	policy := j.overlapPolicy()
+	if policy == OverlapAllow {
+		g.running++
+		g.mutex.Unlock()
+		return ctx, func() {
+			g.mutex.Lock()
+			g.running--
+			g.mutex.Unlock()
+		}, 0, true
+	}
+
+	if g.slot == nil {
+		g.slot = make(chan struct{}, 1)
+	}
+	slot := g.slot
+
+	var waited time.Duration
+	select {
+	case slot <- struct{}{}:
+	default:
+		queued := false
+		switch policy {
+		case OverlapSkip:
+			g.mutex.Unlock()
+			return nil, nil, 0, false
+		case OverlapQueue:
+			if depth := j.overlapQueueDepth(); depth > 0 && g.queued >= depth {
+				g.mutex.Unlock()
+				return nil, nil, 0, false
+			}
+			g.queued++
+			queued = true
+		case OverlapReplace:
+			if g.cancel != nil {
+				g.cancel(errRunReplaced)
+			}
+		}
+		g.mutex.Unlock()
+
+		start := time.Now()
+		acquired := false
+		select {
+		case slot <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+
+		g.mutex.Lock()
+		if queued {
+			g.queued--
+			waited = time.Since(start)
+		}
+		if !acquired {
+			g.mutex.Unlock()
+			return nil, nil, 0, false
+		}
+	}
+	defer g.mutex.Unlock()
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	g.cancel = cancel
+	g.running++
+
+	return runCtx, func() {
+		g.mutex.Lock()
+		g.running--
+		g.cancel = nil
+		g.mutex.Unlock()
+		cancel(nil)
+		<-slot
+	}, waited, true
+}
+
+// skipRun records a run that did not happen because of the overlap policy.
+func (j *JobSpec) skipRun(jr JobRun, trigger string) JobRun {
+	status := StatusSkipped
+	jr.Status = &status
+
+	switch j.overlapPolicy() {
+	case OverlapQueue:
+		jr.Log = fmt.Sprintf("Run skipped, queue of previous runs is full (overlap_policy: %s)", OverlapQueue)
+	default:
+		jr.Log = fmt.Sprintf("Run skipped, previous run still in progress (overlap_policy: %s)", j.overlapPolicy())
+	}
+	j.log.Info().Str("job", j.Name).Str("trigger", trigger).Msg(jr.Log)
+
+	j.record(&jr)
+	return jr
+}
+
+// cancelRun records a run that was cancelled while waiting for its turn.
+func (j *JobSpec) cancelRun(ctx context.Context, jr JobRun) JobRun {
+	status, reason := cancelStatus(ctx)
+	jr.Status = &status
+	jr.Log = fmt.Sprintf("Job cancelled %s", reason)
+
+	j.record(&jr)
+	if status == StatusCancelled {
+		j.OnEvent(&jr)
+	}
+	return jr
+}
+
+// record saves a run that did not execute, without launching on_events.
+func (j *JobSpec) record(jr *JobRun) {
+	jr.logToDb()
+	j.untrackLog(jr)
+	j.observeRun(jr, false)
+	if j.cfg.DB == nil {
+		j.addRun(*jr)
+	}
+}
+
+// overlapState returns the number of running and queued runs.
+func (j *JobSpec) overlapState() (running, queued int) {
+	g := j.guard()
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.running, g.queued
+}
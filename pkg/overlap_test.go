@@ -0,0 +1,194 @@
+package cheek
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForRunning(t *testing.T, j *JobSpec, n int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		running, _ := j.overlapState()
+		return running == n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func runStatuses(j *JobSpec) []int {
+	var statuses []int
+	for _, jr := range j.Runs {
+		if jr.Status != nil {
+			statuses = append(statuses, *jr.Status)
+		}
+	}
+	return statuses
+}
+
+func TestOverlapPolicyValidation(t *testing.T) {
+	j := &JobSpec{Name: "test", OverlapPolicy: "sometimes"}
+	assert.Error(t, j.ValidateOverlapPolicy())
+
+	j.OverlapPolicy = OverlapAllow
+	j.DisableConcurrentExecution = true
+	assert.Error(t, j.ValidateOverlapPolicy())
+
+	j.OverlapPolicy = OverlapQueue
+	j.OverlapQueueDepth = -1
+	assert.Error(t, j.ValidateOverlapPolicy())
+
+	j.OverlapQueueDepth = 2
+	assert.NoError(t, j.ValidateOverlapPolicy())
+	assert.Equal(t, OverlapAllow, (&JobSpec{}).overlapPolicy())
+	assert.Equal(t, OverlapQueue, (&JobSpec{DisableConcurrentExecution: true}).overlapPolicy())
+	assert.Equal(t, 0, (&JobSpec{DisableConcurrentExecution: true}).overlapQueueDepth(), "disable_concurrent_execution should queue without limit")
+	assert.Equal(t, defaultOverlapQueueDepth, (&JobSpec{OverlapPolicy: OverlapQueue}).overlapQueueDepth())
+}
+
+func TestOverlapAllow(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"j": {Command: []string{"sleep", "0.3"}},
+	})
+	j := s.Jobs["j"]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.run(context.Background(), "test", nil)
+		}()
+	}
+	waitForRunning(t, j, 2)
+	wg.Wait()
+
+	assert.Equal(t, []int{StatusOK, StatusOK}, runStatuses(j))
+}
+
+func TestOverlapSkip(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"j": {Command: []string{"sleep", "0.3"}, OverlapPolicy: OverlapSkip},
+	})
+	j := s.Jobs["j"]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.run(context.Background(), "test", nil)
+	}()
+	waitForRunning(t, j, 1)
+
+	j.run(context.Background(), "test", nil)
+	<-done
+
+	assert.Equal(t, []int{StatusSkipped, StatusOK}, runStatuses(j))
+	assert.Contains(t, j.Runs[0].Log, "previous run still in progress")
+}
+
+func TestOverlapQueue(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"j": {Command: []string{"sleep", "0.3"}, OverlapPolicy: OverlapQueue, OverlapQueueDepth: 1},
+	})
+	j := s.Jobs["j"]
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		j.run(context.Background(), "test", nil)
+	}()
+	waitForRunning(t, j, 1)
+	go func() {
+		defer wg.Done()
+		j.run(context.Background(), "test", nil)
+	}()
+	assert.Eventually(t, func() bool {
+		_, queued := j.overlapState()
+		return queued == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the queue is full, so this run is skipped
+	j.run(context.Background(), "test", nil)
+	wg.Wait()
+
+	assert.Equal(t, []int{StatusSkipped, StatusOK, StatusOK}, runStatuses(j))
+	assert.Contains(t, j.Runs[0].Log, "queue of previous runs is full")
+	assert.Contains(t, j.Runs[2].Log, "Run was queued for")
+	assert.GreaterOrEqual(t, j.Runs[2].Duration, time.Duration(450), "the duration of a queued run includes its wait")
+}
+
+func TestOverlapReplace(t *testing.T) {
+	// only the first run finds no marker and keeps going
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"j": {
+			Command:          []string{"sh", "-c", "if mkdir marker 2>/dev/null; then echo start; sleep 10; else echo fresh; fi"},
+			WorkingDirectory: t.TempDir(),
+			OverlapPolicy:    OverlapReplace,
+			KillGracePeriod:  100 * time.Millisecond,
+			OnError:          OnEvent{TriggerJob: []string{"on_error"}},
+		},
+		"on_error": {Command: []string{"true"}},
+	})
+	j := s.Jobs["j"]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.run(context.Background(), "test", nil)
+	}()
+	waitForRunning(t, j, 1)
+
+	j.run(context.Background(), "test", nil)
+	<-done
+
+	assert.Equal(t, []int{StatusReplaced, StatusOK}, runStatuses(j))
+	assert.Contains(t, j.Runs[0].Log, "replaced by a newer run")
+	assert.Contains(t, j.Runs[1].Log, "fresh")
+	assert.Empty(t, s.Jobs["on_error"].Runs, "replaced runs should not fire on_error")
+}
+
+func TestOverlapStateInAPI(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"j": {Command: []string{"sleep", "0.3"}, OverlapPolicy: OverlapQueue},
+	})
+	j := s.Jobs["j"]
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		j.run(context.Background(), "test", nil)
+	}()
+	waitForRunning(t, j, 1)
+	go func() {
+		defer wg.Done()
+		j.run(context.Background(), "test", nil)
+	}()
+	assert.Eventually(t, func() bool {
+		_, queued := j.overlapState()
+		return queued == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	router := setupRouter(s)
+	var jobs map[string]JobResponse
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/jobs", nil))
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&jobs))
+	assert.Equal(t, 1, jobs["j"].Running)
+	assert.Equal(t, 1, jobs["j"].Queued)
+
+	var job JobResponse
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/jobs/j", nil))
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+	assert.Equal(t, 1, job.Running)
+	assert.Equal(t, 1, job.Queued)
+	assert.Contains(t, job.Yaml, "sleep", "the response should carry the spec of the job")
+
+	wg.Wait()
+}
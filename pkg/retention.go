@@ -0,0 +1,294 @@
+package cheek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Defaults for how often the log table gets pruned and vacuumed.
+const (
+	defaultPruneInterval  = time.Hour
+	defaultVacuumInterval = 24 * time.Hour
+)
+
+// RetentionPolicy defines how long the runs of a job are kept in the log
+// table. A zero value means no limit.
+type RetentionPolicy struct {
+	KeepRuns    int `yaml:"keep_runs,omitempty" json:"keep_runs,omitempty"`
+	KeepDays    int `yaml:"keep_days,omitempty" json:"keep_days,omitempty"`
+	MaxLogBytes int `yaml:"max_log_bytes,omitempty" json:"max_log_bytes,omitempty"`
+}
+
+// truncatedPrefix marks a log that was cut to its tail by max_log_bytes.
+const truncatedPrefix = "[truncated] "
+
+// pruneStats summarizes what a prune freed.
+type pruneStats struct {
+	runs      int64
+	truncated int64
+	bytes     int64
+}
+
+func (r RetentionPolicy) validate(name string) error {
+	if r.KeepRuns < 0 || r.KeepDays < 0 || r.MaxLogBytes < 0 {
+		return fmt.Errorf("retention settings for %s cannot be negative", name)
+	}
+	return nil
+}
+
+// merge fills in the settings not set on r from the defaults in d.
+func (r RetentionPolicy) merge(d RetentionPolicy) RetentionPolicy {
+	if r.KeepRuns == 0 {
+		r.KeepRuns = d.KeepRuns
+	}
+	if r.KeepDays == 0 {
+		r.KeepDays = d.KeepDays
+	}
+	if r.MaxLogBytes == 0 {
+		r.MaxLogBytes = d.MaxLogBytes
+	}
+	return r
+}
+
+func (s *Schedule) ValidateRetention() error {
+	if err := s.Retention.validate("the schedule"); err != nil {
+		return err
+	}
+	if err := s.CoreLogRetention.validate("core logs"); err != nil {
+		return err
+	}
+	if s.PruneInterval < 0 || s.VacuumInterval < 0 {
+		return errors.New("prune_interval and vacuum_interval cannot be negative")
+	}
+	return nil
+}
+
+func (s *Schedule) pruneInterval() time.Duration {
+	if s.PruneInterval > 0 {
+		return s.PruneInterval
+	}
+	return defaultPruneInterval
+}
+
+func (s *Schedule) vacuumInterval() time.Duration {
+	if s.VacuumInterval > 0 {
+		return s.VacuumInterval
+	}
+	return defaultVacuumInterval
+}
+
+func (j *JobSpec) ValidateRetention() error {
+	return j.Retention.validate(fmt.Sprintf("job '%s'", j.Name))
+}
+
+// retentionPolicies returns the policy for each job that has runs in the
+// log table. Jobs that are no longer in the schedule get the schedule-wide
+// policy.
+func (s *Schedule) retentionPolicies() (map[string]RetentionPolicy, error) {
+	var names []string
+	if err := s.cfg.DB.Select(&names, "SELECT DISTINCT job FROM log"); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	policies := make(map[string]RetentionPolicy, len(names))
+	for _, name := range names {
+		switch j, ok := s.Jobs[name]; {
+		case name == jobNameCoreProcess:
+			policies[name] = s.CoreLogRetention
+		case ok:
+			policies[name] = j.Retention.merge(s.Retention)
+		default:
+			policies[name] = s.Retention
+		}
+	}
+	return policies, nil
+}
+
+// prune removes runs from the log table according to the retention policies.
+func (s *Schedule) prune() (pruneStats, error) {
+	var stats pruneStats
+
+	sizeBefore, err := s.dbSize()
+	if err != nil {
+		return stats, err
+	}
+
+	policies, err := s.retentionPolicies()
+	if err != nil {
+		return stats, err
+	}
+
+	for job, p := range policies {
+		if p.KeepRuns > 0 {
+			// the attempts of a run are kept or removed along with it
+			res, err := s.cfg.DB.Exec(`
+			DELETE FROM log WHERE job = ? AND COALESCE(NULLIF(parent_run_id, 0), id) NOT IN (
+				SELECT id FROM log WHERE job = ? AND COALESCE(parent_run_id, 0) = 0
+				ORDER BY triggered_at DESC, id DESC LIMIT ?
+			)`, job, job, p.KeepRuns)
+			if err != nil {
+				return stats, fmt.Errorf("prune runs of %s: %w", job, err)
+			}
+			n, _ := res.RowsAffected()
+			stats.runs += n
+		}
+
+		if p.KeepDays > 0 {
+			cutoff := s.now().AddDate(0, 0, -p.KeepDays).UTC().Format("2006-01-02 15:04:05")
+			res, err := s.cfg.DB.Exec("DELETE FROM log WHERE job = ? AND julianday(triggered_at) < julianday(?)", job, cutoff)
+			if err != nil {
+				return stats, fmt.Errorf("prune runs of %s: %w", job, err)
+			}
+			n, _ := res.RowsAffected()
+			stats.runs += n
+		}
+
+		if p.MaxLogBytes > 0 {
+			n, err := s.truncateLogs(job, p.MaxLogBytes)
+			if err != nil {
+				return stats, fmt.Errorf("truncate logs of %s: %w", job, err)
+			}
+			stats.truncated += n
+		}
+	}
+
+	if stats.runs > 0 {
+		_, err := s.cfg.DB.Exec("DELETE FROM run_lineage WHERE run_id NOT IN (SELECT id FROM log) OR parent_run_id NOT IN (SELECT id FROM log)")
+		if err != nil {
+			return stats, fmt.Errorf("prune run lineage: %w", err)
+		}
+		_, err = s.cfg.DB.Exec("DELETE FROM webhook_delivery WHERE run_id NOT IN (SELECT id FROM log)")
+		if err != nil {
+			return stats, fmt.Errorf("prune webhook deliveries: %w", err)
+		}
+	}
+
+	sizeAfter, err := s.dbSize()
+	if err != nil {
+		return stats, err
+	}
+	stats.bytes = sizeBefore - sizeAfter
+
+	return stats, nil
+}
+
+// truncateLogs cuts the logs of the finished runs of job to their last
+// maxBytes, it's where the errors are. It returns the number of logs cut.
+func (s *Schedule) truncateLogs(job string, maxBytes int) (int64, error) {
+	var logs []struct {
+		Id      int    `db:"id"`
+		Message string `db:"message"`
+	}
+	err := s.cfg.DB.Select(&logs, "SELECT id, message FROM log WHERE job = ? AND status IS NOT NULL AND length(CAST(message AS BLOB)) > ?", job, maxBytes+len(truncatedPrefix))
+	if err != nil || len(logs) == 0 {
+		return 0, err
+	}
+
+	tx, err := s.cfg.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, l := range logs {
+		if _, err := tx.Exec("UPDATE log SET message = ? WHERE id = ?", truncatedPrefix+tailBytes(l.Message, maxBytes), l.Id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(logs)), tx.Commit()
+}
+
+// tailBytes returns the last n bytes of s at most, without splitting a UTF-8
+// encoded character.
+func tailBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	i := len(s) - n
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+// vacuum gives the space freed by pruning back to the file system.
+func (s *Schedule) vacuum() (int64, error) {
+	sizeBefore, err := s.dbSize()
+	if err != nil {
+		return 0, err
+	}
+
+	var autoVacuum int
+	if err := s.cfg.DB.Get(&autoVacuum, "PRAGMA auto_vacuum"); err != nil {
+		return 0, err
+	}
+
+	stmt := "VACUUM"
+	if autoVacuum == 2 { // incremental
+		stmt = "PRAGMA incremental_vacuum"
+	}
+	if _, err := s.cfg.DB.Exec(stmt); err != nil {
+		return 0, fmt.Errorf("%s: %w", stmt, err)
+	}
+
+	sizeAfter, err := s.dbSize()
+	if err != nil {
+		return 0, err
+	}
+	return sizeBefore - sizeAfter, nil
+}
+
+// dbSize returns the size in bytes of the pages in use by the db.
+func (s *Schedule) dbSize() (int64, error) {
+	var pages, free, pageSize int64
+	if err := s.cfg.DB.Get(&pages, "PRAGMA page_count"); err != nil {
+		return 0, err
+	}
+	if err := s.cfg.DB.Get(&free, "PRAGMA freelist_count"); err != nil {
+		return 0, err
+	}
+	if err := s.cfg.DB.Get(&pageSize, "PRAGMA page_size"); err != nil {
+		return 0, err
+	}
+	return (pages - free) * pageSize, nil
+}
+
+// pruneLoop periodically prunes the log table until ctx is cancelled.
+func (s *Schedule) pruneLoop(ctx context.Context) {
+	var lastVacuum time.Time
+
+	for {
+		s.mu.RLock()
+		pruneInterval, vacuumInterval := s.pruneInterval(), s.vacuumInterval()
+		s.mu.RUnlock()
+
+		stats, err := s.prune()
+		switch {
+		case err != nil:
+			s.log.Error().Err(err).Msg("Pruning the log table failed")
+		case stats.runs > 0 || stats.truncated > 0:
+			s.log.Info().Int64("runs", stats.runs).Int64("truncated", stats.truncated).Int64("freed_bytes", stats.bytes).Msg("Pruned the log table")
+		}
+
+		if err == nil && stats.runs+stats.truncated > 0 && time.Since(lastVacuum) >= vacuumInterval {
+			freed, err := s.vacuum()
+			if err != nil {
+				s.log.Error().Err(err).Msg("Vacuuming the db failed")
+			} else {
+				lastVacuum = time.Now()
+				s.log.Info().Int64("freed_bytes", freed).Msg("Vacuumed the db")
+			}
+		}
+
+		select {
+		case <-time.After(pruneInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package cheek
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetentionSchedule(t *testing.T) *Schedule {
+	t.Helper()
+	return newTestSchedule(t, true, map[string]*JobSpec{
+		"few":  {Command: []string{"true"}, Retention: RetentionPolicy{KeepRuns: 2}},
+		"many": {Command: []string{"true"}},
+	})
+}
+
+func insertRuns(t *testing.T, s *Schedule, job string, n int, age time.Duration, message string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := s.cfg.DB.Exec("INSERT INTO log (job, triggered_at, triggered_by, status, message) VALUES (?, ?, 'test', 0, ?)", job, s.now().Add(-age-time.Duration(i)*time.Minute), message)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countRuns(t *testing.T, s *Schedule, job string) int {
+	t.Helper()
+	var n int
+	if err := s.cfg.DB.Get(&n, "SELECT COUNT(*) FROM log WHERE job = ?", job); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPruneKeepRuns(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Retention = RetentionPolicy{KeepRuns: 5}
+
+	insertRuns(t, s, "few", 10, 0, "")
+	insertRuns(t, s, "many", 10, 0, "")
+	insertRuns(t, s, "removed_from_schedule", 10, 0, "")
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8+5+5), stats.runs)
+	assert.Equal(t, 2, countRuns(t, s, "few"))
+	assert.Equal(t, 5, countRuns(t, s, "many"))
+	assert.Equal(t, 5, countRuns(t, s, "removed_from_schedule"))
+
+	// the most recent runs are kept
+	var oldest time.Time
+	assert.NoError(t, s.cfg.DB.Get(&oldest, "SELECT triggered_at FROM log WHERE job = 'few' ORDER BY triggered_at LIMIT 1"))
+	assert.WithinDuration(t, s.now().Add(-time.Minute), oldest, 10*time.Second)
+}
+
+func TestPruneKeepDays(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Retention = RetentionPolicy{KeepDays: 7}
+	s.CoreLogRetention = RetentionPolicy{KeepDays: 1}
+
+	insertRuns(t, s, "many", 3, 0, "")
+	insertRuns(t, s, "many", 3, 8*24*time.Hour, "")
+	insertRuns(t, s, jobNameCoreProcess, 3, 2*24*time.Hour, "")
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), stats.runs)
+	assert.Equal(t, 3, countRuns(t, s, "many"))
+	assert.Equal(t, 0, countRuns(t, s, jobNameCoreProcess))
+}
+
+func TestPruneMaxLogBytes(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Jobs["many"].Retention = RetentionPolicy{MaxLogBytes: 100}
+
+	insertRuns(t, s, "many", 1, 0, strings.Repeat("x", 1000)+"the end")
+	insertRuns(t, s, "few", 1, 0, strings.Repeat("x", 1000))
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.truncated)
+
+	var msg string
+	assert.NoError(t, s.cfg.DB.Get(&msg, "SELECT message FROM log WHERE job = 'many'"))
+	assert.Equal(t, "[truncated] ", msg[:12])
+	assert.Len(t, msg, 112)
+	assert.True(t, strings.HasSuffix(msg, "the end"))
+
+	assert.NoError(t, s.cfg.DB.Get(&msg, "SELECT message FROM log WHERE job = 'few'"))
+	assert.Len(t, msg, 1000)
+}
+
+func TestPruneMaxLogBytesMultibyte(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Jobs["many"].Retention = RetentionPolicy{MaxLogBytes: 100}
+
+	insertRuns(t, s, "many", 1, 0, strings.Repeat("é", 1000))
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.truncated)
+
+	var msg string
+	assert.NoError(t, s.cfg.DB.Get(&msg, "SELECT message FROM log WHERE job = 'many'"))
+	assert.Equal(t, "[truncated] "+strings.Repeat("é", 50), msg)
+
+	// a log that was cut is not cut again
+	stats, err = s.prune()
+	assert.NoError(t, err)
+	assert.Zero(t, stats.truncated)
+}
+
+func TestPruneKeepRunsWithAttempts(t *testing.T) {
+	s := newRetentionSchedule(t)
+
+	// three runs of two attempts each, the oldest first
+	for i := 3; i > 0; i-- {
+		var id int
+		err := s.cfg.DB.Get(&id, "INSERT INTO log (job, triggered_at, triggered_by, status) VALUES ('few', ?, 'test', 1) RETURNING id", s.now().Add(-time.Duration(i)*time.Hour))
+		assert.NoError(t, err)
+		_, err = s.cfg.DB.Exec("INSERT INTO log (job, triggered_at, triggered_by, status, attempt, parent_run_id) VALUES ('few', ?, 'test[retry=1]', 1, 1, ?)", s.now().Add(-time.Duration(i)*time.Hour+time.Minute), id)
+		assert.NoError(t, err)
+	}
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.runs)
+	assert.Equal(t, 4, countRuns(t, s, "few"), "both attempts of the two latest runs should be kept")
+
+	var orphans int
+	assert.NoError(t, s.cfg.DB.Get(&orphans, "SELECT COUNT(*) FROM log WHERE parent_run_id != 0 AND parent_run_id NOT IN (SELECT id FROM log)"))
+	assert.Zero(t, orphans)
+}
+
+func TestPruneLineageAndVacuum(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Retention = RetentionPolicy{KeepRuns: 1}
+
+	insertRuns(t, s, "many", 200, 0, strings.Repeat("x", 4096))
+	_, err := s.cfg.DB.Exec("INSERT INTO run_lineage (run_id, parent_run_id) SELECT id, id + 1 FROM log")
+	assert.NoError(t, err)
+	_, err = s.cfg.DB.Exec("INSERT INTO webhook_delivery (run_id, job, webhook, host, sent_at) SELECT id, job, 'generic', 'example.com', triggered_at FROM log")
+	assert.NoError(t, err)
+
+	stats, err := s.prune()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(199), stats.runs)
+	assert.Greater(t, stats.bytes, int64(0))
+
+	var edges int
+	assert.NoError(t, s.cfg.DB.Get(&edges, "SELECT COUNT(*) FROM run_lineage"))
+	assert.Equal(t, 0, edges)
+
+	var deliveries int
+	assert.NoError(t, s.cfg.DB.Get(&deliveries, "SELECT COUNT(*) FROM webhook_delivery"))
+	assert.Equal(t, 1, deliveries)
+
+	_, err = s.vacuum()
+	assert.NoError(t, err)
+}
+
+func TestInvalidRetention(t *testing.T) {
+	s := newRetentionSchedule(t)
+	s.Jobs["few"].Retention.KeepDays = -1
+	assert.Error(t, s.initialize())
+
+	s.Jobs["few"].Retention.KeepDays = 0
+	s.CoreLogRetention.KeepRuns = -1
+	assert.Error(t, s.initialize())
+
+	s.CoreLogRetention.KeepRuns = 0
+	s.PruneInterval = -time.Second
+	assert.Error(t, s.initialize())
+
+	s.PruneInterval = time.Minute
+	assert.NoError(t, s.initialize())
+}
@@ -0,0 +1,117 @@
+package cheek
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Backoff strategies for the delay between retries of a failed run.
+const (
+	RetryBackoffConstant    = "constant"
+	RetryBackoffLinear      = "linear"
+	RetryBackoffExponential = "exponential"
+)
+
+// defaultRetryDelay is the time between retries when no retry_delay is
+// configured.
+const defaultRetryDelay = 5 * time.Second
+
+// defaultRetryMaxDelay bounds the delay between retries when no
+// retry_max_delay is configured, so backoff can't overflow.
+const defaultRetryMaxDelay = 24 * time.Hour
+
+func (j *JobSpec) ValidateRetry() error {
+	if j.Retries < 0 {
+		return fmt.Errorf("retries for job '%s' cannot be negative", j.Name)
+	}
+	switch j.RetryBackoff {
+	case "", RetryBackoffConstant, RetryBackoffLinear, RetryBackoffExponential:
+	default:
+		return fmt.Errorf("retry_backoff '%s' for job '%s' not valid, should be one of %s|%s|%s", j.RetryBackoff, j.Name, RetryBackoffConstant, RetryBackoffLinear, RetryBackoffExponential)
+	}
+	if j.RetryDelay < 0 || j.RetryMaxDelay < 0 {
+		return fmt.Errorf("retry_delay and retry_max_delay for job '%s' cannot be negative", j.Name)
+	}
+	if j.RetryJitter < 0 || j.RetryJitter > 1 {
+		return fmt.Errorf("retry_jitter for job '%s' should be between 0 and 1", j.Name)
+	}
+	if len(j.RetryOnExitCodes) > 0 && len(j.NoRetryOnExitCodes) > 0 {
+		return fmt.Errorf("job '%s' cannot set both retry_on_exit_codes and no_retry_on_exit_codes", j.Name)
+	}
+	return nil
+}
+
+// retryDelay returns the time to wait before the given retry, starting at 1.
+func (j *JobSpec) retryDelay(retry int) time.Duration {
+	delay := defaultRetryDelay
+	if j.RetryDelay > 0 {
+		delay = j.RetryDelay
+	}
+	maxDelay := defaultRetryMaxDelay
+	if j.RetryMaxDelay > 0 {
+		maxDelay = j.RetryMaxDelay
+	}
+
+	switch j.RetryBackoff {
+	case RetryBackoffLinear:
+		if delay > maxDelay/time.Duration(max(retry, 1)) {
+			delay = maxDelay
+		} else {
+			delay *= time.Duration(retry)
+		}
+	case RetryBackoffExponential:
+		for i := 1; i < retry && delay < maxDelay; i++ {
+			delay *= 2
+		}
+	}
+	delay = min(delay, maxDelay)
+
+	if j.RetryJitter > 0 {
+		// spread the delay randomly over +/- retry_jitter of its value
+		delay += time.Duration(float64(delay) * j.RetryJitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// shouldRetry tells whether a failed run with the given status is eligible
+// for a retry according to the exit code filters.
+func (j *JobSpec) shouldRetry(status int) bool {
+	if len(j.RetryOnExitCodes) > 0 {
+		return slices.Contains(j.RetryOnExitCodes, status)
+	}
+	return !slices.Contains(j.NoRetryOnExitCodes, status)
+}
+
+// willRetry reports whether the attempt of a run, with tries attempts before
+// it, is followed by another.
+func (j *JobSpec) willRetry(ctx context.Context, jr JobRun, tries int) bool {
+	return *jr.Status != StatusOK && ctx.Err() == nil && tries+1 < j.Retries+1 && j.shouldRetry(*jr.Status)
+}
+
+// nextAttempt records a new attempt of a failed run. Each attempt gets its own
+// row in the log table, linked to the first attempt of the run.
+func (j *JobSpec) nextAttempt(prev JobRun, attempt int) JobRun {
+	parentRunId := prev.ParentRunId
+	if parentRunId == 0 {
+		parentRunId = prev.LogEntryId
+	}
+
+	jr := JobRun{
+		Name:              j.Name,
+		TriggeredAt:       j.now(),
+		TriggeredBy:       prev.TriggeredBy,
+		TriggeredByJobRun: prev.TriggeredByJobRun,
+		DependencyRuns:    prev.DependencyRuns,
+		RetryAttempt:      attempt,
+		ParentRunId:       parentRunId,
+		TriggerRunId:      prev.TriggerRunId,
+		jobRef:            j,
+	}
+	jr.logToDb()
+	j.trackLog(&jr)
+
+	return jr
+}
@@ -0,0 +1,138 @@
+package cheek
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	j := &JobSpec{}
+	assert.Equal(t, defaultRetryDelay, j.retryDelay(1))
+	assert.Equal(t, defaultRetryDelay, j.retryDelay(3))
+
+	j.RetryDelay = time.Second
+	j.RetryBackoff = RetryBackoffLinear
+	assert.Equal(t, time.Second, j.retryDelay(1))
+	assert.Equal(t, 3*time.Second, j.retryDelay(3))
+
+	j.RetryBackoff = RetryBackoffExponential
+	assert.Equal(t, time.Second, j.retryDelay(1))
+	assert.Equal(t, 2*time.Second, j.retryDelay(2))
+	assert.Equal(t, 8*time.Second, j.retryDelay(4))
+
+	// without retry_max_delay the backoff stops growing rather than overflow
+	assert.Equal(t, defaultRetryMaxDelay, j.retryDelay(100))
+	assert.Equal(t, defaultRetryMaxDelay, j.retryDelay(math.MaxInt32))
+	j.RetryBackoff = RetryBackoffLinear
+	assert.Equal(t, defaultRetryMaxDelay, j.retryDelay(math.MaxInt32))
+	j.RetryBackoff = RetryBackoffExponential
+
+	j.RetryMaxDelay = 5 * time.Second
+	assert.Equal(t, 5*time.Second, j.retryDelay(4))
+	assert.Equal(t, 5*time.Second, j.retryDelay(1000))
+
+	j.RetryJitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := j.retryDelay(1)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.LessOrEqual(t, d, 1500*time.Millisecond)
+	}
+}
+
+func TestRetryValidation(t *testing.T) {
+	j := &JobSpec{Name: "test", RetryBackoff: "fibonacci"}
+	assert.Error(t, j.ValidateRetry())
+
+	j.RetryBackoff = RetryBackoffExponential
+	j.RetryJitter = 1.5
+	assert.Error(t, j.ValidateRetry())
+
+	j.RetryJitter = 0.2
+	j.RetryOnExitCodes = []int{1}
+	j.NoRetryOnExitCodes = []int{2}
+	assert.Error(t, j.ValidateRetry())
+
+	j.NoRetryOnExitCodes = nil
+	assert.NoError(t, j.ValidateRetry())
+}
+
+func TestRetryExitCodeFilters(t *testing.T) {
+	j := &JobSpec{}
+	assert.True(t, j.shouldRetry(1))
+
+	j.NoRetryOnExitCodes = []int{2}
+	assert.True(t, j.shouldRetry(1))
+	assert.False(t, j.shouldRetry(2))
+
+	j.NoRetryOnExitCodes = nil
+	j.RetryOnExitCodes = []int{75, StatusTimeout}
+	assert.True(t, j.shouldRetry(75))
+	assert.True(t, j.shouldRetry(StatusTimeout))
+	assert.False(t, j.shouldRetry(1))
+}
+
+func TestNoRetryOnExitCode(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"bad_input": {
+			Command:            []string{"sh", "-c", "exit 2"},
+			Retries:            3,
+			RetryDelay:         10 * time.Millisecond,
+			NoRetryOnExitCodes: []int{2},
+			OnRetriesExhausted: OnEvent{TriggerJob: []string{"exhausted"}},
+		},
+		"transient": {
+			Command:            []string{"sh", "-c", "exit 1"},
+			Retries:            3,
+			RetryDelay:         10 * time.Millisecond,
+			NoRetryOnExitCodes: []int{2},
+		},
+		"exhausted": {Command: []string{"true"}},
+	})
+
+	jr := s.Jobs["bad_input"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Len(t, s.Jobs["bad_input"].Runs, 1)
+	assert.False(t, jr.RetriesExhausted)
+	assert.Empty(t, s.Jobs["exhausted"].Runs)
+
+	jr = s.Jobs["transient"].execCommandWithRetry(context.Background(), "test", nil)
+	assert.Len(t, s.Jobs["transient"].Runs, 4)
+	assert.True(t, jr.RetriesExhausted)
+}
+
+func TestRetryAttemptsInDb(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"flaky": {
+			Command:    []string{"false"},
+			Retries:    2,
+			RetryDelay: 10 * time.Millisecond,
+		},
+	})
+	j := s.Jobs["flaky"]
+
+	jr := j.execCommandWithRetry(context.Background(), "test", nil)
+	assert.True(t, jr.RetriesExhausted)
+
+	first, err := j.loadLogFromDb(jr.ParentRunId)
+	assert.NoError(t, err)
+	assert.NoError(t, j.loadAttemptsFromDb(&first))
+	assert.Len(t, first.Attempts, 3)
+	for i, a := range first.Attempts {
+		assert.Equal(t, i, a.RetryAttempt)
+		assert.Equal(t, 1, *a.Status)
+		if i > 0 {
+			assert.Equal(t, first.LogEntryId, a.ParentRunId)
+		}
+	}
+	assert.False(t, first.Attempts[1].RetriesExhausted)
+	assert.True(t, first.Attempts[2].RetriesExhausted)
+
+	// the attempts of a run can be loaded from any of its attempts
+	last, err := j.loadLogFromDb(jr.LogEntryId)
+	assert.NoError(t, err)
+	assert.NoError(t, j.loadAttemptsFromDb(&last))
+	assert.Equal(t, first.Attempts, last.Attempts)
+}
@@ -2,9 +2,15 @@ package cheek
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -12,6 +18,7 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
 )
 
@@ -21,18 +28,57 @@ type Schedule struct {
 	OnSuccess          OnEvent             `yaml:"on_success,omitempty" json:"on_success,omitempty"`
 	OnError            OnEvent             `yaml:"on_error,omitempty" json:"on_error,omitempty"`
 	OnRetriesExhausted OnEvent             `yaml:"on_retries_exhausted,omitempty" json:"on_retries_exhausted,omitempty"`
+	OnTimeout          OnEvent             `yaml:"on_timeout,omitempty" json:"on_timeout,omitempty"`
+	OnCancel           OnEvent             `yaml:"on_cancel,omitempty" json:"on_cancel,omitempty"`
+	OnStart            OnEvent             `yaml:"on_start,omitempty" json:"on_start,omitempty"`
+	OnRecovery         OnEvent             `yaml:"on_recovery,omitempty" json:"on_recovery,omitempty"`
+	OnMissed           OnEvent             `yaml:"on_missed,omitempty" json:"on_missed,omitempty"`
+	OnDurationExceeded OnEvent             `yaml:"on_duration_exceeded,omitempty" json:"on_duration_exceeded,omitempty"`
+	NotifyOn           string              `yaml:"notify_on,omitempty" json:"notify_on,omitempty"`
+	AlertAfterFailures int                 `yaml:"alert_after_failures,omitempty" json:"alert_after_failures,omitempty"`
 	TZLocation         string              `yaml:"tz_location,omitempty" json:"tz_location,omitempty"`
+	Retention          RetentionPolicy     `yaml:"retention,omitempty" json:"retention,omitempty"`
+	CoreLogRetention   RetentionPolicy     `yaml:"core_log_retention,omitempty" json:"core_log_retention,omitempty"`
+	PruneInterval      time.Duration       `yaml:"prune_interval,omitempty" json:"prune_interval,omitempty"`
+	VacuumInterval     time.Duration       `yaml:"vacuum_interval,omitempty" json:"vacuum_interval,omitempty"`
+	APITokens          []APIToken          `yaml:"api_tokens,omitempty" json:"-"`
+	APITokensFile      string              `yaml:"api_tokens_file,omitempty" json:"-"`
+	SessionTTL         time.Duration       `yaml:"session_ttl,omitempty" json:"session_ttl,omitempty"`
+	ListenAddress      string              `yaml:"listen_address,omitempty" json:"listen_address,omitempty"`
+	TLSCertFile        string              `yaml:"tls_cert_file,omitempty" json:"tls_cert_file,omitempty"`
+	TLSKeyFile         string              `yaml:"tls_key_file,omitempty" json:"tls_key_file,omitempty"`
+	TLSClientCAFile    string              `yaml:"tls_client_ca_file,omitempty" json:"tls_client_ca_file,omitempty"`
+	ReadTimeout        time.Duration       `yaml:"read_timeout,omitempty" json:"read_timeout,omitempty"`
+	WriteTimeout       time.Duration       `yaml:"write_timeout,omitempty" json:"write_timeout,omitempty"`
+	IdleTimeout        time.Duration       `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	PublicURL          string              `yaml:"public_url,omitempty" json:"public_url,omitempty"`
 	loc                *time.Location
 	log                zerolog.Logger
 	cfg                Config
+	fn                 string
+	mu                 sync.RWMutex
+	active             runRegistry
+	live               logRegistry
+	metrics            metricsRegistry
+	tokens             []APIToken
+	sessions           sessionRegistry
+	server             *http.Server
+	deliveries         sync.WaitGroup // notifications being delivered
 }
 
+// reloadDebounce is the time to wait for a burst of file system events
+// (e.g. an editor saving a file) to settle before reloading the schedule.
+const reloadDebounce = 500 * time.Millisecond
+
 func (s *Schedule) Run() {
 	var currentTickTime time.Time
 	s.log.Info().Msg("Scheduler started")
 	ticker := time.NewTicker(1 * time.Second)
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
 
 	if s.cfg.DB != nil {
 		defer func() { _ = s.cfg.DB.Close() }()
@@ -46,8 +92,19 @@ func (s *Schedule) Run() {
 		cancel()
 	}()
 
+	reloads := make(chan struct{}, 1)
+	if s.fn != "" {
+		go s.watch(ctx, reloads)
+	}
+
+	if s.cfg.DB != nil {
+		go s.pruneLoop(ctx)
+	}
+
 	var wg sync.WaitGroup
 
+	s.catchUp(ctx, &wg)
+
 	for {
 		select {
 		case <-ticker.C:
@@ -55,13 +112,19 @@ func (s *Schedule) Run() {
 			currentTickTime = s.now()
 
 			for _, j := range s.Jobs {
-				if j.Cron == "" {
+				nextTick := j.scheduledTick()
+				if nextTick.IsZero() {
 					continue
 				}
 
-				if j.nextTick.Before(currentTickTime) {
+				if nextTick.Before(currentTickTime) {
 					s.log.Debug().Msgf("%v is due", j.Name)
 
+					trigger := j.scheduleKind()
+					if trigger == ScheduleAt {
+						j.markOneShotFired(currentTickTime)
+					}
+
 					if err := j.setNextTick(currentTickTime, false); err != nil {
 						s.log.Fatal().Err(err).Msg("error determining next tick")
 					}
@@ -69,18 +132,30 @@ func (s *Schedule) Run() {
 					wg.Add(1)
 					go func(j *JobSpec) {
 						defer wg.Done()
-						if j.DisableConcurrentExecution {
-							j.mutex.Lock()
-							defer j.mutex.Unlock()
-						}
-						j.execCommandWithRetry(ctx, "cron", nil)
+						j.run(ctx, trigger, nil)
 					}(j)
 				}
 			}
 
+			s.checkMissed(&wg, currentTickTime)
+
+		case <-hups:
+			s.log.Info().Msg("Received SIGHUP, reloading schedule")
+			if err := s.reload(); err != nil {
+				s.log.Error().Err(err).Msg("Schedule reload failed, keeping current schedule")
+			}
+
+		case <-reloads:
+			s.log.Info().Msgf("Schedule file %s changed, reloading schedule", s.fn)
+			if err := s.reload(); err != nil {
+				s.log.Error().Err(err).Msg("Schedule reload failed, keeping current schedule")
+			}
+
 		case <-ctx.Done():
 			s.log.Info().Msg("Shutting down scheduler due to context cancellation")
 			wg.Wait()
+			s.deliveries.Wait()
+			s.shutdownServer()
 			return
 		}
 	}
@@ -104,16 +179,16 @@ func (a *stringArray) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func readSpecs(fn string) (Schedule, error) {
+func readSpecs(fn string) (*Schedule, error) {
 	yfile, err := os.ReadFile(fn)
 	if err != nil {
-		return Schedule{}, err
+		return nil, err
 	}
 
-	specs := Schedule{}
+	specs := &Schedule{}
 
-	if err = yaml.Unmarshal(yfile, &specs); err != nil {
-		return Schedule{}, err
+	if err = yaml.Unmarshal(yfile, specs); err != nil {
+		return nil, err
 	}
 
 	return specs, nil
@@ -132,12 +207,33 @@ func (s *Schedule) initialize() error {
 	}
 	s.loc = loc
 
+	// validate retention policies
+	if err := s.ValidateRetention(); err != nil {
+		return err
+	}
+
+	// validate api tokens
+	if err := s.ValidateAuth(); err != nil {
+		return err
+	}
+
+	// validate http server settings
+	if err := s.ValidateServer(); err != nil {
+		return err
+	}
+
+	// validate notifications
+	if err := s.ValidateNotifications(); err != nil {
+		return err
+	}
+
 	for k, v := range s.Jobs {
 		// check if trigger references exist
-		triggerJobs := append(v.OnSuccess.TriggerJob, v.OnError.TriggerJob...)
-		for _, t := range triggerJobs {
-			if _, ok := s.Jobs[t]; !ok {
-				return fmt.Errorf("cannot find spec of job '%s' that is referenced in job '%s'", t, k)
+		for _, e := range v.events() {
+			for _, t := range e.TriggerJob {
+				if _, ok := s.Jobs[t]; !ok {
+					return fmt.Errorf("cannot find spec of job '%s' that is referenced in job '%s'", t, k)
+				}
 			}
 		}
 		// set some metadata & refs for each job
@@ -147,8 +243,58 @@ func (s *Schedule) initialize() error {
 		v.log = s.log
 		v.cfg = s.cfg
 
-		// validate cron string
-		if err := v.ValidateCron(); err != nil {
+		// validate cron, every or at schedule
+		if err := v.ValidateSchedule(); err != nil {
+			return err
+		}
+
+		// validate time zone
+		if err := v.ValidateTZLocation(); err != nil {
+			return err
+		}
+
+		// validate timeout settings
+		if err := v.ValidateTimeout(); err != nil {
+			return err
+		}
+
+		// validate duration warning
+		if err := v.ValidateWarnAfter(); err != nil {
+			return err
+		}
+
+		// validate catchup policy
+		if err := v.ValidateCatchup(); err != nil {
+			return err
+		}
+
+		// validate missed run detection
+		if err := v.ValidateExpectRunWithin(); err != nil {
+			return err
+		}
+
+		// validate dependency settings
+		if err := v.ValidateDependsOn(); err != nil {
+			return err
+		}
+
+		// validate overlap policy
+		if err := v.ValidateOverlapPolicy(); err != nil {
+			return err
+		}
+
+		// validate retry settings
+		if err := v.ValidateRetry(); err != nil {
+			return err
+		}
+
+		// validate retention policy
+		if err := v.ValidateRetention(); err != nil {
+			return err
+		}
+
+		// validate notifications
+		if err := v.ValidateNotifications(); err != nil {
 			return err
 		}
 
@@ -157,26 +303,302 @@ func (s *Schedule) initialize() error {
 			return err
 		}
 
+		// keep the spec to compare against on reload
+		spec, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		v.spec = spec
+	}
+
+	return s.validateDependencies()
+}
+
+// catchUp replays the cron ticks that were missed while cheek was not
+// running, according to the catchup policy of each job.
+func (s *Schedule) catchUp(ctx context.Context, wg *sync.WaitGroup) {
+	if s.cfg.DB == nil {
+		return
+	}
+
+	now := s.now()
+	for _, j := range s.Jobs {
+		if j.Cron == "" || j.Catchup == "" || j.Catchup == CatchupNone {
+			continue
+		}
+
+		last, err := j.lastScheduledRun()
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // never ran before, nothing was missed
+		}
+		if err != nil {
+			s.log.Warn().Str("job", j.Name).Err(err).Msg("Cannot determine last run, not catching up")
+			continue
+		}
+
+		ticks, err := j.missedTicks(last.In(j.location()), now)
+		if err != nil {
+			s.log.Warn().Str("job", j.Name).Err(err).Msg("Cannot determine missed ticks, not catching up")
+			continue
+		}
+		if len(ticks) == 0 {
+			continue
+		}
+
+		if j.Catchup == CatchupLast {
+			ticks = ticks[len(ticks)-1:]
+		}
+		if len(ticks) > maxCatchupRuns {
+			s.log.Warn().Str("job", j.Name).Msgf("%d ticks were missed, only catching up on the last %d", len(ticks), maxCatchupRuns)
+			ticks = ticks[len(ticks)-maxCatchupRuns:]
+		}
+
+		s.log.Info().Str("job", j.Name).Str("catchup", j.Catchup).Msgf("Catching up on %d missed tick(s)", len(ticks))
+
+		wg.Add(1)
+		go func(j *JobSpec, ticks []time.Time) {
+			defer wg.Done()
+			for _, t := range ticks {
+				if ctx.Err() != nil {
+					return
+				}
+				s.log.Debug().Str("job", j.Name).Time("tick", t).Msg("Replaying missed tick")
+				j.run(ctx, "catchup", nil)
+			}
+		}(j, ticks)
+	}
+}
+
+// reload re-reads and validates the schedule file and swaps in the new job
+// set. Jobs whose spec did not change are kept as is, so their next tick is
+// unaffected. Running instances of changed or removed jobs are left to finish,
+// a changed job takes over their overlap state so its policy still holds.
+// Settings of the web server only take effect after a restart.
+func (s *Schedule) reload() error {
+	ns, err := readSpecs(s.fn)
+	if err != nil {
+		return err
+	}
+	ns.log = s.log
+	ns.cfg = s.cfg
+	ns.fn = s.fn
+
+	if err := ns.initialize(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added, removed, changed []string
+	tzChanged := ns.TZLocation != s.TZLocation
+	jobs := make(map[string]*JobSpec, len(ns.Jobs))
+	for name, nj := range ns.Jobs {
+		oj, ok := s.Jobs[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case !tzChanged && oj.specEqual(nj):
+			jobs[name] = oj
+			continue
+		default:
+			changed = append(changed, name)
+			nj.takeStateOf(oj)
+		}
+		nj.globalSchedule = s
+		jobs[name] = nj
+	}
+	for name := range s.Jobs {
+		if _, ok := ns.Jobs[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	s.Jobs = jobs
+	s.OnSuccess = ns.OnSuccess
+	s.OnError = ns.OnError
+	s.OnRetriesExhausted = ns.OnRetriesExhausted
+	s.OnTimeout = ns.OnTimeout
+	s.OnCancel = ns.OnCancel
+	s.OnStart = ns.OnStart
+	s.OnRecovery = ns.OnRecovery
+	s.OnMissed = ns.OnMissed
+	s.OnDurationExceeded = ns.OnDurationExceeded
+	s.NotifyOn = ns.NotifyOn
+	s.AlertAfterFailures = ns.AlertAfterFailures
+	s.TZLocation = ns.TZLocation
+	s.Retention = ns.Retention
+	s.CoreLogRetention = ns.CoreLogRetention
+	s.PruneInterval = ns.PruneInterval
+	s.VacuumInterval = ns.VacuumInterval
+	s.APITokens = ns.APITokens
+	s.APITokensFile = ns.APITokensFile
+	s.SessionTTL = ns.SessionTTL
+	s.PublicURL = ns.PublicURL
+	s.tokens = ns.tokens
+	s.loc = ns.loc
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	s.log.Info().Strs("added", added).Strs("removed", removed).Strs("changed", changed).Msg("Schedule reloaded")
+	if settings := s.serverChanges(ns); len(settings) > 0 {
+		s.log.Warn().Strs("settings", settings).Msg("Changes to the web server settings need a restart of cheek to take effect")
 	}
 
 	return nil
 }
 
+// serverChanges returns the settings of the web server that differ in ns,
+// the server is set up once when cheek starts.
+func (s *Schedule) serverChanges(ns *Schedule) []string {
+	var settings []string
+	for _, c := range []struct {
+		name    string
+		changed bool
+	}{
+		{"listen_address", s.ListenAddress != ns.ListenAddress},
+		{"tls_cert_file", s.TLSCertFile != ns.TLSCertFile},
+		{"tls_key_file", s.TLSKeyFile != ns.TLSKeyFile},
+		{"tls_client_ca_file", s.TLSClientCAFile != ns.TLSClientCAFile},
+		{"read_timeout", s.ReadTimeout != ns.ReadTimeout},
+		{"write_timeout", s.WriteTimeout != ns.WriteTimeout},
+		{"idle_timeout", s.IdleTimeout != ns.IdleTimeout},
+	} {
+		if c.changed {
+			settings = append(settings, c.name)
+		}
+	}
+	return settings
+}
+
+// takeStateOf hands the state of the previous spec o of a changed job over
+// to j. The overlap state always carries over, the missed run and dependency
+// state only when the settings they follow from didn't change.
+func (j *JobSpec) takeStateOf(o *JobSpec) {
+	j.overlap = o.guard()
+
+	if j.expectRunWithin() == o.expectRunWithin() && j.sameSchedule(o) {
+		o.missed.mutex.Lock()
+		j.missed.lastRun, j.missed.overdue = o.missed.lastRun, o.missed.overdue
+		o.missed.mutex.Unlock()
+	}
+
+	if slices.Equal(j.DependsOn, o.DependsOn) && j.DependsOnCondition == o.DependsOnCondition && j.dependsOnWindow() == o.dependsOnWindow() {
+		o.deps.mutex.Lock()
+		j.deps.started, j.deps.runs = o.deps.started, o.deps.runs
+		o.deps.runs = nil
+		o.deps.mutex.Unlock()
+	}
+}
+
+// sameSchedule reports whether j and o are scheduled the same way.
+func (j *JobSpec) sameSchedule(o *JobSpec) bool {
+	sameAt := j.At == nil && o.At == nil || j.At != nil && o.At != nil && j.At.Equal(*o.At)
+	return j.Cron == o.Cron && j.Every == o.Every && j.EveryAlign == o.EveryAlign && j.EveryOffset == o.EveryOffset && sameAt && j.TZLocation == o.TZLocation
+}
+
+// watch triggers a reload whenever the schedule file changes on disk. The
+// parent directory is watched as editors often replace files instead of
+// writing to them.
+func (s *Schedule) watch(ctx context.Context, reloads chan<- struct{}) {
+	fn, err := filepath.Abs(s.fn)
+	if err != nil {
+		s.log.Warn().Err(err).Msg("Cannot watch schedule file, reloading on change is disabled")
+		return
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.log.Warn().Err(err).Msg("Cannot watch schedule file, reloading on change is disabled")
+		return
+	}
+	defer func() { _ = w.Close() }()
+
+	if err := w.Add(filepath.Dir(fn)); err != nil {
+		s.log.Warn().Err(err).Msg("Cannot watch schedule file, reloading on change is disabled")
+		return
+	}
+
+	var settled <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != fn || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			settled = time.After(reloadDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			s.log.Warn().Err(err).Msg("Error watching schedule file")
+		case <-settled:
+			settled = nil
+			select {
+			case reloads <- struct{}{}:
+			default: // a reload is already pending
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// getJob looks up a job by name, safe for use while the schedule reloads.
+func (s *Schedule) getJob(name string) (*JobSpec, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	j, ok := s.Jobs[name]
+	return j, ok
+}
+
+// jobs returns a snapshot of the current job set.
+func (s *Schedule) jobs() map[string]*JobSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make(map[string]*JobSpec, len(s.Jobs))
+	for k, v := range s.Jobs {
+		jobs[k] = v
+	}
+	return jobs
+}
+
+// event returns a copy of e, one of the events of the schedule, safe for use
+// while the schedule reloads.
+func (s *Schedule) event(e *OnEvent) OnEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return *e
+}
+
+// location returns the location of the schedule, safe for use while the
+// schedule reloads.
+func (s *Schedule) location() *time.Location {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loc
+}
+
 func (s *Schedule) now() time.Time {
-	return time.Now().In(s.loc)
+	return time.Now().In(s.location())
 }
 
-func loadSchedule(log zerolog.Logger, cfg Config, fn string) (Schedule, error) {
+func loadSchedule(log zerolog.Logger, cfg Config, fn string) (*Schedule, error) {
 	s, err := readSpecs(fn)
 	if err != nil {
-		return Schedule{}, err
+		return nil, err
 	}
 	s.log = log
 	s.cfg = cfg
+	s.fn = fn
 
 	// run validations
 	if err := s.initialize(); err != nil {
-		return Schedule{}, err
+		return nil, err
 	}
 	s.log.Info().Msg("Scheduled loaded and validated")
 	return s, nil
@@ -195,7 +617,11 @@ func RunSchedule(log zerolog.Logger, cfg Config, scheduleFn string) error {
 		s.log.Info().Msgf("Initializing (%v/%v) job: %s", i, numberJobs, k)
 		i++
 	}
-	go server(&s)
+	ln, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("start http server: %w", err)
+	}
+	go s.serve(ln)
 	s.Run()
 	return nil
 }
@@ -1,8 +1,12 @@
 package cheek
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +16,35 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// newTestSchedule initializes a schedule of jobs for testing, logging to a
+// temporary db with withDB or else keeping runs in memory.
+func newTestSchedule(t *testing.T, withDB bool, jobs map[string]*JobSpec) *Schedule {
+	t.Helper()
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+	if withDB {
+		db, err := OpenDB(path.Join(t.TempDir(), "test.sqlite3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		cfg.DB = db
+	}
+
+	s := &Schedule{
+		Jobs:       jobs,
+		TZLocation: "UTC",
+		log:        NewLogger("debug", nil, os.Stdout),
+		cfg:        cfg,
+	}
+	if err := s.initialize(); err != nil {
+		t.Fatal(err)
+	}
+	// notifications still being delivered are saved before the db is closed
+	t.Cleanup(s.deliveries.Wait)
+	return s
+}
+
 func TestScheduleRun(t *testing.T) {
 	// rough test
 	// just tries to see if we can get to a job trigger
@@ -165,3 +198,272 @@ jobs:
 	// because jobs can overlap (8 seconds runtime with 3-second jobs starting every second)
 	assert.Greater(t, concurrentStarts, 1, "Expected more than 1 start for concurrent job")
 }
+
+func TestScheduleReload(t *testing.T) {
+	fn := path.Join(t.TempDir(), "schedule.yaml")
+	writeSchedule := func(spec string) {
+		if err := os.WriteFile(fn, []byte(spec), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSchedule(`
+jobs:
+  unchanged:
+    command: echo foo
+    cron: "* * * * *"
+  changed:
+    command: echo bar
+    cron: "* * * * *"
+  removed:
+    command: echo baz
+`)
+
+	b := new(tsBuffer)
+	s, err := loadSchedule(NewLogger("debug", nil, b), Config{}, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged := s.Jobs["unchanged"]
+	changed := s.Jobs["changed"]
+
+	writeSchedule(`
+jobs:
+  unchanged:
+    command: echo foo
+    cron: "* * * * *"
+  changed:
+    command: echo bar
+    cron: "0 0 1 1 *"
+  added:
+    command: echo coffee
+`)
+
+	assert.NoError(t, s.reload())
+	assert.Len(t, s.Jobs, 3)
+	assert.Same(t, unchanged, s.Jobs["unchanged"])
+	assert.NotSame(t, changed, s.Jobs["changed"])
+	assert.Equal(t, s, s.Jobs["changed"].globalSchedule)
+	assert.Equal(t, 1, int(s.Jobs["changed"].nextTick.Month()))
+	assert.Contains(t, b.String(), `"added":["added"],"removed":["removed"],"changed":["changed"]`)
+
+	// an invalid schedule should leave the current one in place
+	writeSchedule(`
+jobs:
+  unchanged:
+    command: echo foo
+    cron: "not a cron"
+`)
+	assert.Error(t, s.reload())
+	assert.Len(t, s.Jobs, 3)
+	assert.Same(t, unchanged, s.Jobs["unchanged"])
+}
+
+func TestScheduleReloadOverlap(t *testing.T) {
+	fn := path.Join(t.TempDir(), "schedule.yaml")
+	writeSchedule := func(command string) {
+		spec := fmt.Sprintf("jobs:\n  slow:\n    command: %s\n    overlap_policy: skip\n", command)
+		if err := os.WriteFile(fn, []byte(spec), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSchedule("sleep 0.5")
+	cfg := NewConfig()
+	cfg.SuppressLogs = true
+	s, err := loadSchedule(NewLogger("debug", nil, os.Stdout), cfg, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.Jobs["slow"]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		old.run(context.Background(), "test", nil)
+	}()
+	waitForRunning(t, old, 1)
+
+	// the new spec of the job should wait for the run of the old one
+	writeSchedule("sleep 0.1")
+	assert.NoError(t, s.reload())
+	assert.NotSame(t, old, s.Jobs["slow"])
+	jr := s.Jobs["slow"].run(context.Background(), "test", nil)
+	<-done
+
+	assert.Equal(t, StatusSkipped, *jr.Status)
+}
+
+func TestScheduleReloadKeepsState(t *testing.T) {
+	fn := path.Join(t.TempDir(), "schedule.yaml")
+	writeSchedule := func(spec string) {
+		if err := os.WriteFile(fn, []byte(spec), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := `
+listen_address: %s
+jobs:
+  up:
+    command: echo up
+  other:
+    command: echo other
+  down:
+    command: %s
+    cron: "%s"
+    expect_run_within: 1h
+    depends_on: [%s]
+`
+
+	writeSchedule(fmt.Sprintf(spec, "127.0.0.1:8081", "echo down", "* * * * *", "up"))
+	b := new(tsBuffer)
+	s, err := loadSchedule(NewLogger("debug", nil, b), Config{}, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastRun := time.Now().Add(-2 * time.Hour)
+	setState := func() {
+		j := s.Jobs["down"]
+		j.missed.lastRun, j.missed.overdue = lastRun, true
+		j.deps.started = lastRun
+		j.deps.runs = map[string]*JobRun{"up": {Name: "up"}}
+	}
+
+	// only the command changed, the job keeps its state
+	setState()
+	writeSchedule(fmt.Sprintf(spec, "127.0.0.1:8081", "echo changed", "* * * * *", "up"))
+	assert.NoError(t, s.reload())
+	j := s.Jobs["down"]
+	assert.True(t, j.missed.lastRun.Equal(lastRun))
+	assert.True(t, j.missed.overdue)
+	assert.True(t, j.deps.started.Equal(lastRun))
+	assert.Contains(t, j.deps.runs, "up")
+	assert.NotContains(t, b.String(), "need a restart")
+
+	// a new schedule resets the missed state but keeps the dependencies
+	setState()
+	writeSchedule(fmt.Sprintf(spec, "127.0.0.1:8081", "echo changed", "0 * * * *", "up"))
+	assert.NoError(t, s.reload())
+	j = s.Jobs["down"]
+	assert.True(t, j.missed.lastRun.IsZero())
+	assert.False(t, j.missed.overdue)
+	assert.Contains(t, j.deps.runs, "up")
+
+	// new dependencies start a new cycle
+	setState()
+	writeSchedule(fmt.Sprintf(spec, "127.0.0.1:8081", "echo changed", "0 * * * *", "up, other"))
+	assert.NoError(t, s.reload())
+	j = s.Jobs["down"]
+	assert.True(t, j.missed.lastRun.Equal(lastRun))
+	assert.True(t, j.deps.started.IsZero())
+	assert.Empty(t, j.deps.runs)
+
+	// web server settings are kept until a restart
+	writeSchedule(fmt.Sprintf(spec, "127.0.0.1:9090", "echo changed", "0 * * * *", "up, other"))
+	assert.NoError(t, s.reload())
+	assert.Equal(t, "127.0.0.1:8081", s.ListenAddress)
+	assert.Contains(t, b.String(), `"settings":["listen_address"]`)
+	assert.Contains(t, b.String(), "need a restart")
+}
+
+func TestScheduleWatch(t *testing.T) {
+	fn := path.Join(t.TempDir(), "schedule.yaml")
+	if err := os.WriteFile(fn, []byte("jobs: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Schedule{fn: fn, log: NewLogger("debug", nil, os.Stdout)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{}, 1)
+	go s.watch(ctx, reloads)
+
+	// give the watcher time to register before touching the file
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(fn, []byte("jobs:\n  foo:\n    command: ls\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected a reload after the schedule file changed")
+	}
+}
+
+func TestMissedTicks(t *testing.T) {
+	j := &JobSpec{
+		Name:          "nightly",
+		Cron:          "0 3 * * *",
+		CatchupWindow: 72 * time.Hour,
+	}
+
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	// last run long ago, limited by the catchup window
+	ticks, err := j.missedTicks(now.Add(-30*24*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 6, 8, 3, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 9, 3, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 10, 3, 0, 0, 0, time.UTC),
+	}, ticks)
+
+	// last run right at the last tick, nothing missed
+	ticks, err = j.missedTicks(time.Date(2024, 6, 10, 3, 0, 0, 0, time.UTC), now)
+	assert.NoError(t, err)
+	assert.Empty(t, ticks)
+}
+
+func TestCatchUp(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"catchup_all": {
+			Command:       []string{"true"},
+			Cron:          "0 0 * * *",
+			Catchup:       CatchupAll,
+			CatchupWindow: 72 * time.Hour,
+		},
+		"catchup_last": {
+			Command:       []string{"true"},
+			Cron:          "0 0 * * *",
+			Catchup:       CatchupLast,
+			CatchupWindow: 72 * time.Hour,
+		},
+		"catchup_none": {
+			Command: []string{"true"},
+			Cron:    "0 0 * * *",
+		},
+	})
+	db := s.cfg.DB
+
+	for name := range s.Jobs {
+		_, err := db.Exec("INSERT INTO log (job, triggered_at, triggered_by, status) VALUES (?, ?, 'cron', 0)", name, time.Now().Add(-10*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	s.catchUp(context.Background(), &wg)
+	wg.Wait()
+
+	for name, expected := range map[string]int{"catchup_all": 3, "catchup_last": 1, "catchup_none": 0} {
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM log WHERE job = ? AND triggered_by = 'catchup'", name); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expected, n, name)
+	}
+}
+
+func TestInvalidCatchup(t *testing.T) {
+	j := &JobSpec{Name: "test", Cron: "* * * * *", Catchup: "sometimes"}
+	assert.Error(t, j.ValidateCatchup())
+
+	j.Catchup = CatchupAll
+	assert.NoError(t, j.ValidateCatchup())
+
+	j.Cron = ""
+	assert.Error(t, j.ValidateCatchup())
+}
@@ -0,0 +1,209 @@
+package cheek
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults for the timeouts of the HTTP server.
+const (
+	defaultReadTimeout  = 30 * time.Second
+	defaultWriteTimeout = 60 * time.Second
+	defaultIdleTimeout  = 2 * time.Minute
+	// serverShutdownTimeout is how long open requests get to finish when
+	// the server shuts down.
+	serverShutdownTimeout = 10 * time.Second
+)
+
+func (s *Schedule) ValidateServer() error {
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		return errors.New("tls_cert_file and tls_key_file should be set together")
+	}
+	if s.TLSClientCAFile != "" && s.TLSCertFile == "" {
+		return errors.New("tls_client_ca_file requires tls_cert_file and tls_key_file")
+	}
+	if s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		return errors.New("read_timeout, write_timeout and idle_timeout cannot be negative")
+	}
+	return nil
+}
+
+// listenAddress combines listen_address with the configured port, unless
+// listen_address holds a port itself.
+func (s *Schedule) listenAddress() string {
+	if _, _, err := net.SplitHostPort(s.ListenAddress); err == nil {
+		return s.ListenAddress
+	}
+	return net.JoinHostPort(s.ListenAddress, s.cfg.Port)
+}
+
+func timeoutOrDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// certReloader serves the certificate in certFile and keyFile, reloading it
+// when either file changes on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	onError  func(error)
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, onError func(error)) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, onError: onError}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// lastModified returns the most recent modification time of the files.
+func (cr *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, fn := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func (cr *certReloader) reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// getCertificate keeps serving the previous certificate when the files on
+// disk can't be loaded, e.g. while they are being replaced.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if modTime, err := cr.lastModified(); err == nil && !modTime.Equal(cr.modTime) {
+		if err := cr.reload(); err != nil {
+			cr.onError(err)
+		}
+	}
+	return cr.cert, nil
+}
+
+// tlsConfig sets up TLS for the server, it returns nil when no certificate
+// is configured.
+func (s *Schedule) tlsConfig() (*tls.Config, error) {
+	if s.TLSCertFile == "" {
+		return nil, nil
+	}
+	cr, err := newCertReloader(s.TLSCertFile, s.TLSKeyFile, func(err error) {
+		s.log.Warn().Err(err).Msg("Couldn't reload TLS certificate, keeping the current one")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+	}
+
+	if s.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(s.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls_client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls_client_ca_file %s", s.TLSClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// listen binds the HTTP server of the schedule. The server is started with
+// serve and stopped by Run when the schedule shuts down.
+func (s *Schedule) listen() (net.Listener, error) {
+	tlsCfg, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", s.listenAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	// streams of live logs end when the server shuts down
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s.server = &http.Server{
+		Handler:      setupRouter(s),
+		TLSConfig:    tlsCfg,
+		ReadTimeout:  timeoutOrDefault(s.ReadTimeout, defaultReadTimeout),
+		WriteTimeout: timeoutOrDefault(s.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:  timeoutOrDefault(s.IdleTimeout, defaultIdleTimeout),
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+	s.server.RegisterOnShutdown(cancel)
+	return ln, nil
+}
+
+func (s *Schedule) serve(ln net.Listener) {
+	var err error
+	if s.server.TLSConfig != nil {
+		s.log.Info().Msgf("Starting HTTPS server on %v", ln.Addr())
+		err = s.server.ServeTLS(ln, "", "")
+	} else {
+		s.log.Info().Msgf("Starting HTTP server on %v", ln.Addr())
+		err = s.server.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.Error().Err(err).Msg("HTTP server stopped")
+	}
+}
+
+// shutdownServer stops the HTTP server, giving open requests some time to
+// finish.
+func (s *Schedule) shutdownServer() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.log.Warn().Err(err).Msg("HTTP server did not shut down cleanly")
+		_ = s.server.Close()
+	}
+	s.log.Info().Msg("HTTP server stopped")
+}
+
+// clearWriteDeadline lifts the write timeout of the server for responses that
+// take long by design, like event streams.
+func clearWriteDeadline(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
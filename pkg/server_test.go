@@ -0,0 +1,228 @@
+package cheek
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir, it
+// returns the paths of both and the parsed certificate.
+func writeTestCert(t *testing.T, dir, name string, client bool) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := path.Join(dir, name+".crt")
+	keyFile := path.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// startTestServer starts the http server of s on a random local port.
+func startTestServer(t *testing.T, s *Schedule) string {
+	t.Helper()
+	s.ListenAddress = "127.0.0.1:0"
+	ln, err := s.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(ln)
+	t.Cleanup(s.shutdownServer)
+	return ln.Addr().String()
+}
+
+func TestValidateServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       *Schedule
+		wantErr string
+	}{
+		{"plain", &Schedule{ListenAddress: "127.0.0.1"}, ""},
+		{"tls", &Schedule{TLSCertFile: "a", TLSKeyFile: "b", TLSClientCAFile: "c"}, ""},
+		{"cert without key", &Schedule{TLSCertFile: "a"}, "set together"},
+		{"client ca without tls", &Schedule{TLSClientCAFile: "c"}, "requires tls_cert_file"},
+		{"negative timeout", &Schedule{WriteTimeout: -time.Second}, "cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s.ValidateServer()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	s := Schedule{cfg: Config{Port: "8081"}}
+	assert.Equal(t, ":8081", s.listenAddress())
+	s.ListenAddress = "127.0.0.1"
+	assert.Equal(t, "127.0.0.1:8081", s.listenAddress())
+	s.ListenAddress = "::1"
+	assert.Equal(t, "[::1]:8081", s.listenAddress())
+	s.ListenAddress = "127.0.0.1:9000"
+	assert.Equal(t, "127.0.0.1:9000", s.listenAddress())
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeTestCert(t, dir, "server", false)
+	clientCert, clientKey, _ := writeTestCert(t, dir, "client", true)
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{"bertha": {Command: []string{"true"}}})
+	s.TLSCertFile = certFile
+	s.TLSKeyFile = keyFile
+	s.TLSClientCAFile = clientCert
+	addr := startTestServer(t, s)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	// without a client certificate the handshake fails
+	_, err := client().Get("https://" + addr + "/healthz/")
+	assert.Error(t, err)
+
+	kp, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client(kp).Get("https://" + addr + "/healthz/")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, cert.SerialNumber, resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	// a renewed certificate is picked up without a restart
+	newCertFile, newKeyFile, newCert := writeTestCert(t, dir, "renewed", false)
+	assert.NoError(t, os.Rename(newCertFile, certFile))
+	assert.NoError(t, os.Rename(newKeyFile, keyFile))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	roots.AddCert(newCert)
+
+	c := client(kp)
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+	resp, err = c.Get("https://" + addr + "/healthz/")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, newCert.SerialNumber, resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+}
+
+func TestCertReloaderKeepsCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeTestCert(t, dir, "server", false)
+
+	var reloadErr error
+	cr, err := newCertReloader(certFile, keyFile, func(err error) { reloadErr = err })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a half written certificate
+	assert.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+
+	got, err := cr.getCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Raw, got.Certificate[0])
+	assert.Error(t, reloadErr)
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{"bertha": {Command: []string{"true"}}})
+	s.WriteTimeout = 50 * time.Millisecond
+	addr := startTestServer(t, s)
+
+	// a run that is still in progress keeps its stream open
+	jr := s.Jobs["bertha"].setup("test", nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/api/jobs/bertha/runs/%d/stream", addr, jr.LogEntryId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the stream outlives the write timeout
+	time.Sleep(100 * time.Millisecond)
+	_, _ = fmt.Fprint(jr.logs(), "still running\n")
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: log\n", line)
+
+	done := make(chan struct{})
+	go func() {
+		s.shutdownServer()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err, "the stream should end cleanly")
+
+	_, err = http.Get(fmt.Sprintf("http://%s/healthz/", addr))
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package cheek
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnStartWebhook(t *testing.T) {
+	payloads := make(chan []byte, 2)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- body
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"slow": {
+			Command: []string{"sleep", "10"},
+			OnStart: OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+		},
+	})
+	s.OnStart = OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL, Template: "{{ .Name }} {{ .StatusText }} {{ .RunId }}"}}}
+	assert.NoError(t, s.ValidateNotifications())
+
+	// the notifications arrive while the job is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan JobRun)
+	go func() { done <- s.Jobs["slow"].run(ctx, "test", nil) }()
+	var generic struct {
+		Id         int    `json:"id"`
+		Name       string `json:"name"`
+		Status     *int   `json:"status"`
+		InProgress bool   `json:"in_progress"`
+	}
+	var templated string
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-payloads:
+			if json.Unmarshal(body, &generic) != nil {
+				templated = string(body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("on_start webhooks were not called")
+		}
+	}
+	cancel()
+	jr := <-done
+	s.deliveries.Wait()
+
+	assert.Equal(t, jr.LogEntryId, generic.Id)
+	assert.Equal(t, "slow", generic.Name)
+	assert.Nil(t, generic.Status)
+	assert.True(t, generic.InProgress)
+	assert.Equal(t, "slow running "+strconv.Itoa(jr.LogEntryId), templated)
+}
+
+func TestOnStartSkippedRun(t *testing.T) {
+	var calls atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"slow": {
+			Command:       []string{"sleep", "10"},
+			OverlapPolicy: OverlapSkip,
+			OnStart:       OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+		},
+	})
+	j := s.Jobs["slow"]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan JobRun)
+	go func() { done <- j.run(ctx, "test", nil) }()
+	waitForRunning(t, j, 1)
+
+	// a run that doesn't get to start doesn't announce itself
+	skipped := j.run(context.Background(), "test", nil)
+	assert.Equal(t, StatusSkipped, *skipped.Status)
+
+	cancel()
+	<-done
+	s.deliveries.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestOnStartTriggerJob(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"main":    {Command: []string{"true"}, OnStart: OnEvent{TriggerJob: []string{"sidecar"}}},
+		"sidecar": {Command: []string{"echo", "sidecar"}},
+	})
+
+	s.Jobs["main"].run(context.Background(), "test", nil)
+	assert.Eventually(t, func() bool {
+		var triggeredBy []string
+		err := s.cfg.DB.Select(&triggeredBy, "SELECT triggered_by FROM log WHERE job = 'sidecar' AND status IS NOT NULL")
+		return err == nil && len(triggeredBy) == 1 && triggeredBy[0] == "start[main]"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,88 @@
+package cheek
+
+import (
+	"sync"
+	"time"
+)
+
+// logCheckpointInterval is how often the output of a running job is saved to
+// the db, so it can be looked at while the job runs and survives a crash.
+var logCheckpointInterval = 10 * time.Second
+
+// logRegistry keeps track of the output of runs in progress, keyed by run id,
+// so it can be streamed.
+type logRegistry struct {
+	mutex sync.Mutex
+	logs  map[int]*tsBuffer
+}
+
+func (r *logRegistry) add(id int, b *tsBuffer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.logs == nil {
+		r.logs = make(map[int]*tsBuffer)
+	}
+	r.logs[id] = b
+}
+
+func (r *logRegistry) remove(id int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.logs, id)
+}
+
+func (r *logRegistry) get(id int) (*tsBuffer, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, ok := r.logs[id]
+	return b, ok
+}
+
+// trackLog makes the output of a run that has been set up available for
+// streaming.
+func (j *JobSpec) trackLog(jr *JobRun) {
+	if j.globalSchedule == nil || jr.LogEntryId == 0 {
+		return
+	}
+	j.globalSchedule.live.add(jr.LogEntryId, jr.logs())
+}
+
+// untrackLog ends the stream of a run's output once the run is done.
+func (j *JobSpec) untrackLog(jr *JobRun) {
+	_ = jr.logs().Close()
+	if j.globalSchedule != nil && jr.LogEntryId != 0 {
+		j.globalSchedule.live.remove(jr.LogEntryId)
+	}
+}
+
+// checkpointLogs periodically saves the output of a running job to the db.
+// The returned func stops checkpointing.
+func (j *JobSpec) checkpointLogs(jr *JobRun) func() {
+	if j.cfg.DB == nil || jr.LogEntryId == 0 {
+		return func() {}
+	}
+
+	id, b := jr.LogEntryId, jr.logs()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(logCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := j.cfg.DB.Exec("UPDATE log SET message = ? WHERE id = ?", b.String(), id); err != nil {
+					j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't checkpoint job log to db.")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,98 @@
+package cheek
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTsBufferSince(t *testing.T) {
+	b := new(tsBuffer)
+	_, _ = b.Write([]byte("foo"))
+
+	data, change, closed := b.since(0)
+	assert.Equal(t, "foo", string(data))
+	assert.False(t, closed)
+
+	_, _ = b.Write([]byte("bar"))
+	select {
+	case <-change:
+	default:
+		t.Fatal("change should be signalled on write")
+	}
+
+	data, change, _ = b.since(3)
+	assert.Equal(t, "bar", string(data))
+
+	_ = b.Close()
+	<-change
+	data, _, closed = b.since(6)
+	assert.Empty(t, data)
+	assert.True(t, closed)
+}
+
+func TestCheckpointLogs(t *testing.T) {
+	defer func(d time.Duration) { logCheckpointInterval = d }(logCheckpointInterval)
+	logCheckpointInterval = 20 * time.Millisecond
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"long": {Command: []string{"sh", "-c", "echo checkpointed; sleep 10"}, KillGracePeriod: 100 * time.Millisecond},
+	})
+
+	jr, done := startTestRun(s.Jobs["long"])
+	assert.Eventually(t, func() bool {
+		saved, err := s.Jobs["long"].loadLogFromDb(jr.LogEntryId)
+		return err == nil && saved.Status == nil && saved.Log == "checkpointed\n"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	s.CancelRun(s.Jobs["long"], jr.LogEntryId)
+	waitForRun(t, done)
+}
+
+func TestJobRunStream(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"chatty": {Command: []string{"sh", "-c", "echo one; sleep 0.3; echo two"}},
+	})
+	srv := httptest.NewServer(setupRouter(s))
+	defer srv.Close()
+
+	jr, done := startTestRun(s.Jobs["chatty"])
+	url := fmt.Sprintf("%s/api/jobs/chatty/runs/%d/stream", srv.URL, jr.LogEntryId)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	waitForRun(t, done)
+
+	assert.Contains(t, string(body), "event: log\ndata: {\"log\":\"one\\n\"}\n\n")
+	assert.Contains(t, string(body), "two")
+	assert.Contains(t, string(body), "event: end\ndata: {\"status\":0}\n\n")
+
+	// a finished run streams its saved output
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Contains(t, string(body), "event: log\ndata: {\"log\":\"one\\ntwo\\n\"}\n\n")
+	assert.Contains(t, string(body), "event: end\ndata: {\"status\":0}\n\n")
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/jobs/chatty/runs/%d/stream", srv.URL, jr.LogEntryId+100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
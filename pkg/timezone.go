@@ -0,0 +1,113 @@
+package cheek
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/adhocore/gronx"
+)
+
+// maxWallTicks bounds the number of cron ticks looked at to find the next
+// one that exists in a location, ticks in a repeated hour can be passed over.
+const maxWallTicks = 100
+
+// location returns the location the cron string of the job is read in, the
+// job's own tz_location or else that of the schedule.
+func (j *JobSpec) location() *time.Location {
+	if j.loc != nil {
+		return j.loc
+	}
+	if j.globalSchedule != nil {
+		if loc := j.globalSchedule.location(); loc != nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+func (j *JobSpec) ValidateTZLocation() error {
+	j.loc = nil
+	if j.TZLocation == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(j.TZLocation)
+	if err != nil {
+		return fmt.Errorf("tz_location '%s' for job '%s' not valid: %w", j.TZLocation, j.Name, err)
+	}
+	j.loc = loc
+	return nil
+}
+
+// nextTicks returns the next tick of the job in its own location and in UTC,
+// nil when it has none.
+func (j *JobSpec) nextTicks() (*time.Time, *time.Time) {
+	nextTick := j.scheduledTick()
+	if nextTick.IsZero() {
+		return nil, nil
+	}
+	local, utc := nextTick.In(j.location()), nextTick.UTC()
+	return &local, &utc
+}
+
+// nextTickIn returns the first tick of expr after ref, or at ref with
+// inclRefTime, in the location of ref. The cron string is matched against the
+// wall clock, so across DST transitions:
+//
+//   - a tick in an hour that is skipped runs at the transition, ticks that
+//     fall in the same gap are merged into that one run;
+//   - a tick in an hour that is repeated runs in the first occurrence only,
+//     or in the second when the first one has already passed.
+func nextTickIn(expr string, ref time.Time, inclRefTime bool) (time.Time, error) {
+	loc := ref.Location()
+	wall := wallClock(ref)
+	incl := inclRefTime
+	for i := 0; i < maxWallTicks; i++ {
+		next, err := gronx.NextTickAfter(expr, wall, incl)
+		if err != nil {
+			return next, err
+		}
+		for _, t := range wallInstants(next, loc) {
+			if t.After(ref) || (inclRefTime && t.Equal(ref)) {
+				return t, nil
+			}
+		}
+		wall, incl = next, false
+	}
+	return ref, fmt.Errorf("no tick found for '%s' after %s", expr, ref)
+}
+
+// wallClock returns the wall clock of t as a time in UTC, which has no DST.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// wallInstants returns the instants that show wall clock w in loc, earliest
+// first. That is two instants in a repeated hour, and none in a skipped one,
+// in which case the transition right after the gap is returned.
+func wallInstants(w time.Time, loc *time.Location) []time.Time {
+	// DST transitions are far enough apart for the offsets half a day before
+	// and after to be all offsets in play
+	var instants []time.Time
+	var later time.Time
+	for _, probe := range []time.Time{w.Add(-12 * time.Hour), w.Add(12 * time.Hour)} {
+		_, offset := probe.In(loc).Zone()
+		t := w.Add(-time.Duration(offset) * time.Second).In(loc)
+		if wallClock(t).Equal(w) {
+			if len(instants) == 0 || !instants[0].Equal(t) {
+				instants = append(instants, t)
+			}
+		} else if later.IsZero() || t.After(later) {
+			later = t
+		}
+	}
+
+	if len(instants) == 0 && !later.IsZero() {
+		// w falls in a gap, the transition is the start of the zone that
+		// follows it
+		start, _ := later.ZoneBounds()
+		return []time.Time{start}
+	}
+	sort.Slice(instants, func(a, b int) bool { return instants[a].Before(instants[b]) })
+	return instants
+}
@@ -0,0 +1,105 @@
+package cheek
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestNextTickDST(t *testing.T) {
+	brussels := loadLocation(t, "Europe/Brussels")
+	utc := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		cron  string
+		ref   time.Time
+		ticks []time.Time // the next ticks, in UTC
+	}{
+		// on March 31 2024 clocks go from 02:00 CET to 03:00 CEST (01:00 UTC)
+		{"skipped hour runs at the transition", "30 2 * * *", utc(time.March, 30, 12, 0),
+			[]time.Time{utc(time.March, 31, 1, 0), utc(time.April, 1, 0, 30)}},
+		{"skipped ticks run once", "*/15 2 * * *", utc(time.March, 31, 0, 59),
+			[]time.Time{utc(time.March, 31, 1, 0), utc(time.April, 1, 0, 0)}},
+		{"hourly across the gap", "0 * * * *", utc(time.March, 31, 0, 30),
+			[]time.Time{utc(time.March, 31, 1, 0), utc(time.March, 31, 2, 0)}},
+		// on October 27 2024 clocks go from 03:00 CEST back to 02:00 CET (01:00 UTC)
+		{"repeated hour runs once", "30 2 * * *", utc(time.October, 26, 12, 0),
+			[]time.Time{utc(time.October, 27, 0, 30), utc(time.October, 28, 1, 30)}},
+		{"repeated ticks run once", "*/30 * * * *", utc(time.October, 26, 23, 45),
+			[]time.Time{utc(time.October, 27, 0, 0), utc(time.October, 27, 0, 30), utc(time.October, 27, 2, 0)}},
+		{"started in the repeated hour", "*/30 * * * *", utc(time.October, 27, 1, 10),
+			[]time.Time{utc(time.October, 27, 1, 30), utc(time.October, 27, 2, 0)}},
+		{"unaffected", "0 12 * * *", utc(time.October, 26, 12, 0),
+			[]time.Time{utc(time.October, 27, 11, 0), utc(time.October, 28, 11, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := tt.ref.In(brussels)
+			for _, want := range tt.ticks {
+				next, err := nextTickIn(tt.cron, ref, false)
+				assert.NoError(t, err)
+				assert.Equal(t, want, next.UTC())
+				assert.Equal(t, brussels, next.Location())
+				ref = next
+			}
+		})
+	}
+}
+
+func TestJobTZLocation(t *testing.T) {
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"brussels":  {Command: []string{"true"}, Cron: "0 9 * * *", TZLocation: "Europe/Brussels"},
+		"new_york":  {Command: []string{"true"}, Cron: "0 9 * * *", TZLocation: "America/New_York"},
+		"singapore": {Command: []string{"true"}, Cron: "0 9 * * *", TZLocation: "Asia/Singapore"},
+		"default":   {Command: []string{"true"}, Cron: "0 9 * * *"},
+	})
+	assert.Equal(t, s.loc, s.Jobs["default"].location())
+
+	ref := time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)
+	for name, want := range map[string]time.Time{
+		"brussels":  time.Date(2024, time.July, 1, 7, 0, 0, 0, time.UTC),
+		"new_york":  time.Date(2024, time.July, 1, 13, 0, 0, 0, time.UTC),
+		"singapore": time.Date(2024, time.July, 1, 1, 0, 0, 0, time.UTC),
+	} {
+		j := s.Jobs[name]
+		assert.NoError(t, j.setNextTick(ref, false))
+		assert.Equal(t, want, j.nextTick.UTC(), name)
+		assert.Equal(t, 9, j.nextTick.Hour(), name)
+	}
+
+	// the next ticks show up in /api/jobs
+	req := httptest.NewRequest("GET", "/api/jobs", nil)
+	resp := httptest.NewRecorder()
+	setupRouter(s).ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var jobs map[string]struct {
+		TZLocation  string `json:"tz_location"`
+		NextTick    string `json:"next_tick"`
+		NextTickUTC string `json:"next_tick_utc"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&jobs))
+	assert.Equal(t, "America/New_York", jobs["new_york"].TZLocation)
+	assert.Equal(t, "2024-07-01T09:00:00-04:00", jobs["new_york"].NextTick)
+	assert.Equal(t, "2024-07-01T13:00:00Z", jobs["new_york"].NextTickUTC)
+	assert.Equal(t, "2024-07-01T09:00:00+08:00", jobs["singapore"].NextTick)
+
+	j := JobSpec{Name: "job", TZLocation: "Mars/Olympus_Mons"}
+	assert.ErrorContains(t, j.ValidateTZLocation(), "tz_location 'Mars/Olympus_Mons' for job 'job' not valid")
+}
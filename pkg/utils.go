@@ -15,9 +15,13 @@ import (
 
 const jobNameCoreProcess = "_cheek"
 
+// tsBuffer is a concurrency-safe buffer. Readers can follow what gets
+// written to it through since, until the buffer is closed.
 type tsBuffer struct {
-	b bytes.Buffer
-	m sync.Mutex
+	b      bytes.Buffer
+	m      sync.Mutex
+	closed bool
+	change chan struct{}
 }
 
 func (b *tsBuffer) Read(p []byte) (n int, err error) {
@@ -29,9 +33,40 @@ func (b *tsBuffer) Read(p []byte) (n int, err error) {
 func (b *tsBuffer) Write(p []byte) (n int, err error) {
 	b.m.Lock()
 	defer b.m.Unlock()
+	defer b.signal()
 	return b.b.Write(p)
 }
 
+// Close marks the end of what gets written to the buffer.
+func (b *tsBuffer) Close() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.closed = true
+	b.signal()
+	return nil
+}
+
+// since returns what was written to the buffer from offset on, a channel
+// that gets closed on the next change and whether the buffer was closed.
+func (b *tsBuffer) since(offset int) ([]byte, <-chan struct{}, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	data := b.b.Bytes()
+	data = bytes.Clone(data[min(offset, len(data)):])
+	if b.change == nil {
+		b.change = make(chan struct{})
+	}
+	return data, b.change, b.closed
+}
+
+// signal wakes up the readers waiting for a change, b.m should be held.
+func (b *tsBuffer) signal() {
+	if b.change != nil {
+		close(b.change)
+		b.change = nil
+	}
+}
+
 func (b *tsBuffer) String() string {
 	b.m.Lock()
 	defer b.m.Unlock()
@@ -0,0 +1,77 @@
+package cheek
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+func (j *JobSpec) ValidateWarnAfter() error {
+	if j.WarnAfter < 0 {
+		return fmt.Errorf("warn_after for job '%s' cannot be negative", j.Name)
+	}
+	if j.Timeout > 0 && j.WarnAfter >= j.Timeout {
+		return fmt.Errorf("warn_after for job '%s' should be shorter than its timeout", j.Name)
+	}
+	return nil
+}
+
+// watchDuration fires on_duration_exceeded when the run is still going
+// warn_after after it was triggered, while the run carries on. It returns a
+// func to stop watching, which reports whether the run crossed warn_after.
+// Once stopping returns the run is no longer flagged, the event itself
+// doesn't hold up the run.
+func (j *JobSpec) watchDuration(jr *JobRun) func() bool {
+	if j.WarnAfter <= 0 {
+		return func() bool { return false }
+	}
+
+	// the run goes on in the meantime, so the event gets a copy of it
+	run := *jr
+	var (
+		mutex    sync.Mutex
+		stopped  bool
+		exceeded bool
+	)
+	// flag marks the run as having crossed warn_after, unless it finished in
+	// the meantime
+	flag := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if stopped {
+			return false
+		}
+		exceeded = true
+		if j.cfg.DB != nil && run.LogEntryId != 0 {
+			if _, err := j.cfg.DB.Exec("UPDATE log SET duration_exceeded = true WHERE id = ?", run.LogEntryId); err != nil {
+				j.log.Warn().Str("job", j.Name).Err(err).Msg("Couldn't flag job run in db.")
+			}
+		}
+		return true
+	}
+	timer := time.AfterFunc(time.Until(jr.TriggeredAt.Add(j.WarnAfter)), func() {
+		if !flag() {
+			return
+		}
+		run.DurationExceeded = true
+		run.Log = run.logs().String()
+		run.Duration = time.Duration(time.Since(run.TriggeredAt).Milliseconds())
+
+		j.log.Warn().Str("job", j.Name).Msgf("Job still running after %v", j.WarnAfter)
+		j.OnDurationExceededEvent(&run)
+	})
+
+	return func() bool {
+		timer.Stop()
+		mutex.Lock()
+		defer mutex.Unlock()
+		stopped = true
+		return exceeded
+	}
+}
+
+// OnDurationExceededEvent fires the on_duration_exceeded events of a run that
+// is still going after warn_after.
+func (j *JobSpec) OnDurationExceededEvent(jr *JobRun) {
+	j.fireEvents(jr, "duration_exceeded", j.OnDurationExceeded, func(s *Schedule) *OnEvent { return &s.OnDurationExceeded })
+}
@@ -0,0 +1,111 @@
+package cheek
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWarnAfter(t *testing.T) {
+	j := JobSpec{Name: "job", WarnAfter: -time.Second}
+	assert.ErrorContains(t, j.ValidateWarnAfter(), "cannot be negative")
+
+	j = JobSpec{Name: "job", WarnAfter: time.Minute, Timeout: time.Minute}
+	assert.ErrorContains(t, j.ValidateWarnAfter(), "shorter than its timeout")
+
+	j = JobSpec{Name: "job", WarnAfter: time.Minute, Timeout: time.Hour}
+	assert.NoError(t, j.ValidateWarnAfter())
+}
+
+func TestOnDurationExceeded(t *testing.T) {
+	payloads := make(chan []byte, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- body
+	}))
+	defer testServer.Close()
+
+	s := newTestSchedule(t, true, map[string]*JobSpec{
+		"slow": {
+			Command:            []string{"sleep", "0.5"},
+			WarnAfter:          100 * time.Millisecond,
+			OnDurationExceeded: OnEvent{NotifyWebhook: []WebhookTarget{{URL: testServer.URL}}},
+		},
+		"fast": {Command: []string{"true"}, WarnAfter: time.Minute},
+	})
+
+	jr := s.Jobs["slow"].run(context.Background(), "test", nil)
+
+	// the job was warned about while it went on
+	select {
+	case body := <-payloads:
+		var payload struct {
+			Id               int   `json:"id"`
+			Status           *int  `json:"status"`
+			InProgress       bool  `json:"in_progress"`
+			DurationExceeded bool  `json:"duration_exceeded"`
+			Duration         int64 `json:"duration"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, jr.LogEntryId, payload.Id)
+		assert.Nil(t, payload.Status)
+		assert.True(t, payload.InProgress)
+		assert.True(t, payload.DurationExceeded)
+		assert.GreaterOrEqual(t, payload.Duration, int64(100))
+	case <-time.After(5 * time.Second):
+		t.Fatal("on_duration_exceeded webhook was not called")
+	}
+	assert.Equal(t, StatusOK, *jr.Status)
+	assert.True(t, jr.DurationExceeded)
+
+	stored, err := s.Jobs["slow"].loadLogFromDb(jr.LogEntryId)
+	assert.NoError(t, err)
+	assert.True(t, stored.DurationExceeded)
+
+	jr = s.Jobs["fast"].run(context.Background(), "test", nil)
+	assert.False(t, jr.DurationExceeded)
+}
+
+func TestDurationExceededEventDoesNotHoldUpRun(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"slow": {
+			Command:            []string{"sleep", "0.3"},
+			WarnAfter:          50 * time.Millisecond,
+			OnDurationExceeded: OnEvent{TriggerJob: []string{"page"}},
+		},
+		"page": {Command: []string{"sleep", "2"}},
+	})
+
+	jr := s.Jobs["slow"].run(context.Background(), "test", nil)
+	assert.True(t, jr.DurationExceeded)
+	assert.Less(t, jr.Duration, time.Duration(1500), "the run should not wait for the triggered job")
+	assert.Empty(t, s.Jobs["page"].runs(), "the triggered job should still be running")
+
+	assert.Eventually(t, func() bool {
+		return len(s.Jobs["page"].runs()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStoppedWatchdogDoesNotFire(t *testing.T) {
+	s := newTestSchedule(t, false, map[string]*JobSpec{
+		"slow": {
+			Command:            []string{"true"},
+			WarnAfter:          20 * time.Millisecond,
+			OnDurationExceeded: OnEvent{TriggerJob: []string{"page"}},
+		},
+		"page": {Command: []string{"true"}},
+	})
+	j := s.Jobs["slow"]
+
+	jr := j.setup("test", nil)
+	stop := j.watchDuration(&jr)
+	assert.False(t, stop())
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, s.Jobs["page"].runs())
+}
@@ -4,6 +4,8 @@ document.addEventListener('alpine:init', () => {
     jobName: null,
     jobRun: null,
     runId: null,
+    lineage: null,
+    stream: null,
 
     fetchSpec: async function () {
       try {
@@ -24,6 +26,43 @@ document.addEventListener('alpine:init', () => {
         }
         this.jobRun = await response.json();
         this.runId = this.jobRun.id // update runId to the actual runId
+        // lineage is linked to the first attempt of a run
+        this.fetchLineage(this.jobRun.parent_run_id || this.runId)
+        // tail the output of a run in progress
+        if (this.jobRun.id && this.jobRun.status === undefined) {
+          this.streamLog(this.jobRun.id)
+        }
+      } catch (error) {
+        console.error('Fetch error:', error);
+      }
+    },
+    streamLog: function (runId) {
+      if (this.stream) {
+        this.stream.close();
+      }
+      let log = '';
+      this.stream = new EventSource(`/api/jobs/${this.jobName}/runs/${runId}/stream`);
+      // the stream starts from the beginning of the output, also on reconnect
+      this.stream.onopen = () => {
+        log = '';
+      };
+      this.stream.addEventListener('log', (e) => {
+        log += JSON.parse(e.data).log;
+        this.jobRun.log = log;
+      });
+      this.stream.addEventListener('end', () => {
+        this.stream.close();
+        this.stream = null;
+        this.fetchJobRun(runId);
+      });
+    },
+    fetchLineage: async function (runId) {
+      try {
+        const response = await fetch(`/api/jobs/${this.jobName}/runs/${runId}/lineage`);
+        if (!response.ok) {
+          throw new Error('Network response was not ok');
+        }
+        this.lineage = await response.json();
       } catch (error) {
         console.error('Fetch error:', error);
       }
@@ -123,15 +162,29 @@ document.addEventListener('alpine:init', () => {
 })
 
 
+// triggerJob starts a run of the job, resolving to the id of the new run
 function triggerJob(jobName) {
-  fetch(`/api/jobs/${jobName}/trigger`, {
+  return fetch(`/api/jobs/${jobName}/trigger`, {
     method: 'POST',
   }).then(response => {
-    if (response.ok) {
-      console.log(`Job ${jobName} triggered!`);
-    } else {
+    if (!response.ok) {
       console.error(`Job ${jobName} could not be triggered!`);
+      return null;
+    }
+    console.log(`Job ${jobName} triggered!`);
+    return response.json().then(data => data.run_id || null);
+  });
+}
+
+// cancelRun stops a run of the job that is in progress
+function cancelRun(jobName, runId) {
+  return fetch(`/api/jobs/${jobName}/runs/${runId}/cancel`, {
+    method: 'POST',
+  }).then(response => {
+    if (!response.ok) {
+      console.error(`Run ${runId} of job ${jobName} could not be cancelled!`);
     }
+    return response.ok;
   });
 }
 
@@ -153,6 +206,25 @@ function parseJobUrl(url) {
 }
 
 
+function runStatusText(status) {
+  switch (status) {
+    case 0:
+      return 'Success';
+    case undefined:
+      return 'Running';
+    case -2:
+      return 'Timed out';
+    case -3:
+      return 'Skipped';
+    case -4:
+      return 'Replaced';
+    case -5:
+      return 'Cancelled';
+    default:
+      return 'Failed';
+  }
+}
+
 function truncateDateTime(dateTimeStr) {
   // Regular expression to match the date and time up to the minute
   const regex = /^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2})/;
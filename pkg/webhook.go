@@ -3,56 +3,298 @@ package cheek
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
 )
 
+// webhookLogTailLines is the number of log lines in the LogTail of a
+// notification template.
+const webhookLogTailLines = 20
+
+var webhookMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodGet, http.MethodDelete}
+
 type webhook interface {
 	Call(jr *JobRun) ([]byte, error)
 	URL() string
 	Name() string
 }
 
+// WebhookTarget is an endpoint to notify. In the schedule it is either just
+// the URL, or a mapping to customize the request.
+type WebhookTarget struct {
+	URL      string            `yaml:"url" json:"url"`
+	Method   string            `yaml:"method,omitempty" json:"method,omitempty"`
+	Headers  map[string]secret `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Template string            `yaml:"template,omitempty" json:"template,omitempty"`
+	// Secret signs the body of each call.
+	Secret          secret `yaml:"secret,omitempty" json:"secret,omitempty"`
+	DeliveryOptions `yaml:",inline"`
+	tmpl            *template.Template
+}
+
+// webhookTarget is WebhookTarget without its custom (un)marshalling.
+type webhookTarget WebhookTarget
+
+func (t *WebhookTarget) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.URL = value.Value
+		return nil
+	}
+	return value.Decode((*webhookTarget)(t))
+}
+
+func (t WebhookTarget) isURL() bool {
+	return t.Method == "" && len(t.Headers) == 0 && t.Template == "" && t.Secret == "" &&
+		t.DeliveryOptions == DeliveryOptions{}
+}
+
+func (t WebhookTarget) MarshalYAML() (interface{}, error) {
+	if t.isURL() {
+		return t.URL, nil
+	}
+	return webhookTarget(t), nil
+}
+
+func (t WebhookTarget) MarshalJSON() ([]byte, error) {
+	if t.isURL() {
+		return json.Marshal(t.URL)
+	}
+	return json.Marshal(webhookTarget(t))
+}
+
+// validate checks the target and parses its template.
+func (t *WebhookTarget) validate() error {
+	if t.URL == "" {
+		return errors.New("webhook url cannot be empty")
+	}
+	if t.Method != "" && !slices.Contains(webhookMethods, t.Method) {
+		return fmt.Errorf("webhook method '%s' of %s should be one of %s", t.Method, t.URL, strings.Join(webhookMethods, "|"))
+	}
+	if err := t.DeliveryOptions.validate(); err != nil {
+		return fmt.Errorf("webhook %s: %w", t.URL, err)
+	}
+	if t.Template == "" {
+		return nil
+	}
+	tmpl, err := template.New(t.URL).Funcs(webhookFuncs).Option("missingkey=error").Parse(t.Template)
+	if err != nil {
+		return fmt.Errorf("webhook template of %s: %w", t.URL, err)
+	}
+	t.tmpl = tmpl
+	return nil
+}
+
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, e.g. to safely embed the log in a JSON body
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// tail returns the last n lines of s
+	"tail": tailLines,
+	// truncate cuts s to at most n bytes
+	"truncate": func(n int, s string) string {
+		return truncateBytes(s, n)
+	},
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a UTF-8 encoded
+// character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	n = max(n, 0)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func tailLines(n int, s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n")
+}
+
+// webhookData is what notification templates have access to.
+type webhookData struct {
+	Name             string
+	RunId            int
+	Status           int
+	StatusText       string
+	Duration         time.Duration
+	TriggeredAt      time.Time
+	TriggeredBy      string
+	RetryAttempt     int
+	RetriesExhausted bool
+	DurationExceeded bool
+	Log              string
+	LogTail          string
+	URL              string
+}
+
+func newWebhookData(jr *JobRun) webhookData {
+	d := webhookData{
+		Name:             jr.Name,
+		RunId:            jr.LogEntryId,
+		Duration:         jr.Duration,
+		TriggeredAt:      jr.TriggeredAt,
+		TriggeredBy:      jr.TriggeredBy,
+		RetryAttempt:     jr.RetryAttempt,
+		RetriesExhausted: jr.RetriesExhausted,
+		DurationExceeded: jr.DurationExceeded,
+		Log:              jr.Log,
+		LogTail:          tailLines(webhookLogTailLines, jr.Log),
+	}
+	if jr.Status != nil {
+		d.Status = *jr.Status
+		d.StatusText = statusLabel(*jr.Status)
+	} else {
+		d.StatusText = "running"
+	}
+	if jr.jobRef != nil && jr.jobRef.globalSchedule != nil && jr.LogEntryId != 0 {
+		d.URL = fmt.Sprintf("%s/jobs/%s/%d", jr.jobRef.globalSchedule.publicURL(), jr.Name, jr.LogEntryId)
+	}
+	return d
+}
+
+// render executes the template of the target, ok is false when it has none.
+func (t WebhookTarget) render(jr *JobRun) (body []byte, ok bool, err error) {
+	if t.tmpl == nil {
+		return nil, false, nil
+	}
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, newWebhookData(jr)); err != nil {
+		return nil, true, fmt.Errorf("render webhook template: %w", err)
+	}
+	return b.Bytes(), true, nil
+}
+
+// message returns the text of a chat notification, the rendered template of
+// the target or a default summary of the run.
+func (t WebhookTarget) message(jr *JobRun) (string, error) {
+	body, ok, err := t.render(jr)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return string(body), nil
+	}
+	if jr.Status == nil && jr.DurationExceeded && jr.jobRef != nil {
+		return fmt.Sprintf("%s still running after %v (run %d)", jr.Name, jr.jobRef.WarnAfter, jr.LogEntryId), nil
+	}
+	if jr.Status == nil {
+		return fmt.Sprintf("%s started (run %d)", jr.Name, jr.LogEntryId), nil
+	}
+	if *jr.Status == StatusMissed {
+		return fmt.Sprintf("%s missed:\n%s", jr.Name, jr.Log), nil
+	}
+	return fmt.Sprintf("%s (exitcode %v):\n%s", jr.Name, *jr.Status, jr.Log), nil
+}
+
+// webhooks returns the webhooks to call for an event.
+func (e OnEvent) webhooks() []webhook {
+	var webhooks []webhook
+	for _, n := range notifiers {
+		webhooks = append(webhooks, n.webhooks(&e)...)
+	}
+	return webhooks
+}
+
+// validate checks all targets of the event.
+func (e *OnEvent) validate() error {
+	for _, n := range notifiers {
+		if err := n.validate(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateNotifications checks the alerting settings and the webhook targets
+// of the schedule wide events.
+func (s *Schedule) ValidateNotifications() error {
+	if err := validateAlerting(s.NotifyOn, s.AlertAfterFailures, "the schedule"); err != nil {
+		return err
+	}
+	for _, e := range s.events() {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// events returns the schedule wide events.
+func (s *Schedule) events() []*OnEvent {
+	return []*OnEvent{&s.OnSuccess, &s.OnError, &s.OnRetriesExhausted, &s.OnTimeout, &s.OnCancel, &s.OnRecovery, &s.OnStart, &s.OnMissed, &s.OnDurationExceeded}
+}
+
+// publicURL is the base URL of the web UI, used to link to runs.
+func (s *Schedule) publicURL() string {
+	if s.PublicURL != "" {
+		return strings.TrimSuffix(s.PublicURL, "/")
+	}
+	scheme := "http"
+	if s.TLSCertFile != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://localhost:%s", scheme, s.cfg.Port)
+}
+
+// secrets collects the headers, secrets and passwords of all targets, as
+// they are masked when marshalling.
+func (e OnEvent) secrets() []any {
+	var secrets []any
+	for _, n := range notifiers {
+		secrets = append(secrets, n.secrets(&e)...)
+	}
+	return secrets
+}
+
+// secrets returns what is masked of the target when marshalling.
+func (t *WebhookTarget) secrets() []any {
+	return []any{t.Headers, t.Secret}
+}
+
 // Discord Webhook
 
 type discordWebhook struct {
-	endpoint string
+	target WebhookTarget
 }
 
 func NewDiscordWebhook(endpoint string) discordWebhook {
-	return discordWebhook{endpoint}
+	return discordWebhook{WebhookTarget{URL: endpoint}}
 }
 
 func (dw discordWebhook) Call(jr *JobRun) ([]byte, error) {
 	type discordPayload struct {
 		Content string `json:"content"`
 	}
-	payload := bytes.Buffer{}
-	msg := fmt.Sprintf("%s (exitcode %v):\n%s", jr.Name, *jr.Status, jr.Log)
-	d := discordPayload{
-		Content: msg[:min(len(msg), 2000)], // discord accepts a max. of 2000 chars
-	}
-	if err := json.NewEncoder(&payload).Encode(d); err != nil {
-		return nil, err
-	}
-
-	resp, err := http.Post(dw.endpoint, "application/json", bytes.NewBuffer(payload.Bytes()))
+	msg, err := dw.target.message(jr)
 	if err != nil {
 		return []byte{}, err
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	resp_body, err := io.ReadAll(resp.Body)
+	payload, err := encodeJSON(discordPayload{
+		Content: truncateBytes(msg, 2000), // discord accepts a max. of 2000 chars
+	})
 	if err != nil {
-		return []byte{}, err
+		return nil, err
 	}
 
-	return resp_body, nil
+	return dw.target.send(jr, dw.Name(), payload)
 }
 
 func (dw discordWebhook) URL() string {
-	return dw.endpoint
+	return dw.target.URL
 }
 
 func (dw discordWebhook) Name() string {
@@ -62,42 +304,33 @@ func (dw discordWebhook) Name() string {
 // Slack Webhook
 
 type slackWebhook struct {
-	endpoint string
+	target WebhookTarget
 }
 
 func NewSlackWebhook(endpoint string) slackWebhook {
-	return slackWebhook{endpoint}
+	return slackWebhook{WebhookTarget{URL: endpoint}}
 }
 
 func (dw slackWebhook) Call(jr *JobRun) ([]byte, error) {
 	type slackPayload struct {
 		Text string `json:"text"`
 	}
-	payload := bytes.Buffer{}
-	msg := fmt.Sprintf("%s (exitcode %v):\n%s", jr.Name, *jr.Status, jr.Log)
-	d := slackPayload{
-		Text: msg[:min(len(msg), 40000)], // slack accepts a max. of 40000 chars
-	}
-	if err := json.NewEncoder(&payload).Encode(d); err != nil {
-		return nil, err
-	}
-
-	resp, err := http.Post(dw.endpoint, "application/json", bytes.NewBuffer(payload.Bytes()))
+	msg, err := dw.target.message(jr)
 	if err != nil {
 		return []byte{}, err
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	resp_body, err := io.ReadAll(resp.Body)
+	payload, err := encodeJSON(slackPayload{
+		Text: truncateBytes(msg, 40000), // slack accepts a max. of 40000 chars
+	})
 	if err != nil {
-		return []byte{}, err
+		return nil, err
 	}
 
-	return resp_body, nil
+	return dw.target.send(jr, dw.Name(), payload)
 }
 
 func (dw slackWebhook) URL() string {
-	return dw.endpoint
+	return dw.target.URL
 }
 
 func (dw slackWebhook) Name() string {
@@ -107,35 +340,38 @@ func (dw slackWebhook) Name() string {
 // Default Webhook
 
 type defaultWebhook struct {
-	endpoint string
+	target WebhookTarget
 }
 
 func NewDefaultWebhook(endpoint string) defaultWebhook {
-	return defaultWebhook{endpoint}
+	return defaultWebhook{WebhookTarget{URL: endpoint}}
 }
 
 func (dw defaultWebhook) Call(jr *JobRun) ([]byte, error) {
-	payload := bytes.Buffer{}
-	if err := json.NewEncoder(&payload).Encode(jr); err != nil {
-		return []byte{}, err
-	}
-
-	resp, err := http.Post(dw.endpoint, "application/json", bytes.NewBuffer(payload.Bytes()))
+	// a template replaces the whole body
+	body, ok, err := dw.target.render(jr)
 	if err != nil {
 		return []byte{}, err
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	resp_body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return []byte{}, err
+	if !ok {
+		var payload any = jr
+		if jr.Status == nil {
+			// a run that just started
+			payload = struct {
+				*JobRun
+				InProgress bool `json:"in_progress"`
+			}{jr, true}
+		}
+		if body, err = encodeJSON(payload); err != nil {
+			return []byte{}, err
+		}
 	}
 
-	return resp_body, nil
+	return dw.target.send(jr, dw.Name(), body)
 }
 
 func (dw defaultWebhook) URL() string {
-	return dw.endpoint
+	return dw.target.URL
 }
 
 func (dw defaultWebhook) Name() string {
@@ -1,13 +1,18 @@
 package cheek
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestJobRunWebhookCall(t *testing.T) {
@@ -54,3 +59,149 @@ func TestJobRunWebhookCall(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp_body), `{"content":"test (exitcode 0):\nthis is a random log statement\nwith multiple lines\nand stuff"}`)
 }
+
+func TestWebhookTargetYAML(t *testing.T) {
+	var e OnEvent
+	err := yaml.Unmarshal([]byte(`
+notify_webhook:
+  - https://example.com/plain
+  - url: https://example.com/custom
+    method: PUT
+    headers:
+      Authorization: Bearer secret-token
+    template: '{"job": {{ json .Name }}}'
+    secret: signing-key
+    timeout: 5s
+`), &e)
+	assert.NoError(t, err)
+	if assert.Len(t, e.NotifyWebhook, 2) {
+		assert.Equal(t, WebhookTarget{URL: "https://example.com/plain"}, e.NotifyWebhook[0])
+		assert.Equal(t, "PUT", e.NotifyWebhook[1].Method)
+		assert.Equal(t, secret("Bearer secret-token"), e.NotifyWebhook[1].Headers["Authorization"])
+		assert.Equal(t, 5*time.Second, e.NotifyWebhook[1].Timeout)
+	}
+
+	// plain targets stay plain, headers are masked
+	out, err := yaml.Marshal(e)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "- https://example.com/plain\n")
+	assert.Contains(t, string(out), "Authorization: '***'")
+	assert.NotContains(t, string(out), "secret-token")
+	assert.NotContains(t, string(out), "signing-key")
+
+	js, err := json.Marshal(e)
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"notify_webhook":["https://example.com/plain",{"url":"https://example.com/custom"`)
+	assert.NotContains(t, string(js), "secret-token")
+}
+
+func TestWebhookTargetValidate(t *testing.T) {
+	tests := []struct {
+		target  WebhookTarget
+		wantErr string
+	}{
+		{WebhookTarget{URL: "https://example.com"}, ""},
+		{WebhookTarget{URL: "https://example.com", Method: "PUT", Template: "{{ .Name }}"}, ""},
+		{WebhookTarget{}, "url cannot be empty"},
+		{WebhookTarget{URL: "https://example.com", Method: "put"}, "should be one of POST|PUT|PATCH|GET|DELETE"},
+		{WebhookTarget{URL: "https://example.com", Template: "{{ .Name "}, "webhook template of https://example.com"},
+		{WebhookTarget{URL: "https://example.com", Template: "{{ nope .Name }}"}, "function \"nope\" not defined"},
+		{WebhookTarget{URL: "https://example.com", DeliveryOptions: DeliveryOptions{Timeout: -time.Second}}, "cannot be negative"},
+		{WebhookTarget{URL: "https://example.com", DeliveryOptions: DeliveryOptions{Retries: new(int)}}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.target.validate()
+		if tt.wantErr == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTemplatedWebhookCall(t *testing.T) {
+	type request struct {
+		method string
+		header http.Header
+		body   string
+	}
+	requests := make(chan request, 1)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.Header, string(body)}
+	}))
+	defer testServer.Close()
+
+	status := StatusTimeout
+	jr := JobRun{
+		LogEntryId:   42,
+		Status:       &status,
+		Name:         "backup",
+		TriggeredBy:  "cron",
+		Duration:     90 * time.Second,
+		RetryAttempt: 1,
+		Log:          "line 1\nline 2\nline \"3\"\n",
+		jobRef:       &JobSpec{globalSchedule: &Schedule{PublicURL: "https://cheek.example.com/"}},
+	}
+
+	target := WebhookTarget{
+		URL:     testServer.URL,
+		Method:  http.MethodPut,
+		Headers: map[string]secret{"Authorization": "Bearer abc", "Content-Type": "text/plain"},
+		Template: `{{ .Name }} {{ .StatusText }} ({{ .Status }}) after {{ .Duration }} by {{ .TriggeredBy }}, attempt {{ .RetryAttempt }}
+{{ tail 1 .Log | json }}
+{{ .URL }}`,
+	}
+	assert.NoError(t, target.validate())
+
+	_, err := defaultWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	req := <-requests
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "Bearer abc", req.header.Get("Authorization"))
+	assert.Equal(t, "text/plain", req.header.Get("Content-Type"))
+	assert.Equal(t, "backup timeout (-2) after 1m30s by cron, attempt 1\n\"line \\\"3\\\"\"\nhttps://cheek.example.com/jobs/backup/42", req.body)
+
+	// chat webhooks wrap the rendered message in their payload
+	target = WebhookTarget{URL: testServer.URL, Template: "{{ .Name }} failed"}
+	assert.NoError(t, target.validate())
+	_, err = slackWebhook{target}.Call(&jr)
+	assert.NoError(t, err)
+	req = <-requests
+	assert.Equal(t, http.MethodPost, req.method)
+	assert.Equal(t, "{\"text\":\"backup failed\"}\n", req.body)
+
+	// the template is checked against the available fields
+	target = WebhookTarget{URL: testServer.URL, Template: "{{ .Nope }}"}
+	assert.NoError(t, target.validate())
+	_, err = discordWebhook{target}.Call(&jr)
+	assert.ErrorContains(t, err, "render webhook template")
+}
+
+func TestTailLines(t *testing.T) {
+	assert.Equal(t, "b\nc", tailLines(2, "a\nb\nc\n"))
+	assert.Equal(t, "a\nb", tailLines(5, "a\nb"))
+	assert.Equal(t, "", tailLines(2, ""))
+}
+
+func TestTruncateBytes(t *testing.T) {
+	assert.Equal(t, "abc", truncateBytes("abc", 5))
+	assert.Equal(t, "ab", truncateBytes("abc", 2))
+	assert.Equal(t, "a", truncateBytes("aé", 2), "a character should not be split")
+	assert.Equal(t, "aé", truncateBytes("aé€", 5))
+	assert.Equal(t, "", truncateBytes("€", 2))
+
+	msg := truncateBytes("a"+strings.Repeat("é", 3000), 4096)
+	assert.True(t, utf8.ValidString(msg))
+	assert.Len(t, msg, 4095)
+}
+
+func TestSpecEqualWebhookHeaders(t *testing.T) {
+	a := &JobSpec{Command: []string{"true"}, OnError: OnEvent{NotifyWebhook: []WebhookTarget{{URL: "https://example.com", Headers: map[string]secret{"X-Token": "a"}}}}}
+	b := &JobSpec{Command: []string{"true"}, OnError: OnEvent{NotifyWebhook: []WebhookTarget{{URL: "https://example.com", Headers: map[string]secret{"X-Token": "b"}}}}}
+	assert.True(t, a.specEqual(a))
+	assert.False(t, a.specEqual(b), "changed header values should be detected although they are masked")
+
+	c := &JobSpec{Command: []string{"true"}, OnError: OnEvent{NotifyWebhook: []WebhookTarget{{URL: "https://example.com", Headers: map[string]secret{"X-Token": "a"}, Secret: "c"}}}}
+	assert.False(t, a.specEqual(c), "changed secrets should be detected although they are masked")
+}